@@ -11,18 +11,23 @@ import (
 )
 
 type JSONPayload struct {
-	Email string `json:"email"`
+	UserID string `json:"user_id"`
+	Email  string `json:"email,omitempty"`
 }
 
 func (app *Config) WriteAnalysis(w http.ResponseWriter, r *http.Request) {
 
 	// read json into var
 	var requestPayload JSONPayload
-	app.readJSON(w, r, &requestPayload)
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil || requestPayload.UserID == "" {
+		// actions are counted only by stable user ID, emails aren`t stored
+		app.errorJSON(w, fmt.Errorf("user_id is required"))
+		return
+	}
 
-	// insert data
 	event := data.ActionsUser{
-		Email: requestPayload.Email,
+		UserID: requestPayload.UserID,
 	}
 
 	value, err := app.Models.ActionsUser.Get(event)
@@ -48,6 +53,69 @@ func (app *Config) WriteAnalysis(w http.ResponseWriter, r *http.Request) {
 	app.writeJSON(w, http.StatusAccepted, resp)
 }
 
+func (app *Config) EraseAnalysis(w http.ResponseWriter, r *http.Request) {
+	var requestPayload JSONPayload
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil || requestPayload.UserID == "" || requestPayload.Email == "" {
+		app.errorJSON(w, fmt.Errorf("user_id and email are required"))
+		return
+	}
+
+	// not yet saved actions are stored in redis, old ones by email
+	err = app.Models.ActionsUser.DeleteSome([]string{
+		"actions " + requestPayload.UserID,
+		"actions " + requestPayload.Email,
+	})
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.Models.AnalysisUser.DeleteByUser(requestPayload.UserID, requestPayload.Email)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	resp := jsonResponse{
+		Error:   false,
+		Message: "erased",
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+func (app *Config) BackfillAnalysis(w http.ResponseWriter, r *http.Request) {
+	var requestPayload JSONPayload
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil || requestPayload.UserID == "" || requestPayload.Email == "" {
+		app.errorJSON(w, fmt.Errorf("user_id and email are required"))
+		return
+	}
+
+	// move actions counted by email to user ID
+	err = app.Models.ActionsUser.Move(requestPayload.Email, requestPayload.UserID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.Models.AnalysisUser.Backfill(requestPayload.UserID, requestPayload.Email)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	resp := jsonResponse{
+		Error:   false,
+		Message: "backfilled",
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
 func (app *Config) UpdateDB(done chan bool) {
 	ticker := time.NewTicker(30 * time.Second)
 	defer ticker.Stop()
@@ -88,7 +156,7 @@ func (app *Config) UpdateDB(done chan bool) {
 			}
 
 			for _, au := range analUsers {
-				v, ok := keyValues[fmt.Sprintf("actions %s", au.Email)]
+				v, ok := keyValues[fmt.Sprintf("actions %s", au.UserID)]
 				if ok {
 					actions, err := strconv.Atoi(v)
 					if err != nil {
@@ -107,7 +175,7 @@ func (app *Config) UpdateDB(done chan bool) {
 
 			for key, val := range keyValues {
 				key = strings.ReplaceAll(key, "actions ", "")
-				analUser, err := app.Models.AnalysisUser.GetOneByEmail(key)
+				analUser, err := app.Models.AnalysisUser.GetOneByUserID(key)
 				if err != nil {
 					log.Println(err)
 				}
@@ -120,7 +188,7 @@ func (app *Config) UpdateDB(done chan bool) {
 
 					err = app.Models.AnalysisUser.Insert(
 						data.AnalysisUser{
-							Email:           key,
+							UserID:          key,
 							Actions:         actions,
 							PercentActivity: 100 * float64(actions) / float64(totalActions),
 						})
@@ -24,6 +24,8 @@ func (app *Config) routes() http.Handler {
 	mux.Use(middleware.Heartbeat("/ping"))
 
 	mux.Post("/analysis", app.WriteAnalysis)
+	mux.Post("/erase", app.EraseAnalysis)
+	mux.Post("/backfill", app.BackfillAnalysis)
 
 	return mux
 }
@@ -13,6 +13,9 @@ import (
 	"time"
 )
 
+// maxMoveRetries is how many times moving of actions is retried when keys are changed
+const maxMoveRetries = 10
+
 var rclient *redis.Client
 var mclient *mongo.Client
 
@@ -32,13 +35,14 @@ type Models struct {
 }
 
 type ActionsUser struct {
-	Email   string `json:"email"`
+	UserID  string `json:"user_id"`
 	Actions int    `json:"actions"`
 }
 
 type AnalysisUser struct {
 	ID              string    `bson:"_id,omitempty" json:"id,omitempty"`
-	Email           string    `bson:"email" json:"email"`
+	UserID          string    `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	Email           string    `bson:"email,omitempty" json:"email,omitempty"`
 	Actions         int       `bson:"actions" json:"actions"`
 	PercentActivity float64   `bson:"percent_activity" json:"percent_activity"`
 	CreatedAt       time.Time `bson:"created_at" json:"created_at"`
@@ -46,7 +50,7 @@ type AnalysisUser struct {
 }
 
 func (a *ActionsUser) Set(entry ActionsUser) error {
-	err := rclient.Set(context.Background(), "actions "+entry.Email, entry.Actions, 0).Err()
+	err := rclient.Set(context.Background(), "actions "+entry.UserID, entry.Actions, 0).Err()
 	if err != nil {
 		return err
 	}
@@ -55,7 +59,7 @@ func (a *ActionsUser) Set(entry ActionsUser) error {
 }
 
 func (a *ActionsUser) Get(entry ActionsUser) (int, error) {
-	val, err := rclient.Get(context.Background(), "actions "+entry.Email).Result()
+	val, err := rclient.Get(context.Background(), "actions "+entry.UserID).Result()
 	if err != nil {
 		if errors.Is(err, redis.Nil) {
 			return 0, nil
@@ -128,10 +132,45 @@ func (*ActionsUser) DeleteOne(key string) error {
 	return nil
 }
 
+// Move adds actions counted under old key to new key, keys are watched so
+// actions written between reading and moving them aren`t lost
+func (*ActionsUser) Move(oldKey, newKey string) error {
+	ctx := context.Background()
+	oldKey, newKey = "actions "+oldKey, "actions "+newKey
+
+	move := func(tx *redis.Tx) error {
+		val, err := tx.Get(ctx, oldKey).Int()
+		if err != nil {
+			if errors.Is(err, redis.Nil) {
+				return nil
+			}
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.IncrBy(ctx, newKey, int64(val))
+			pipe.Del(ctx, oldKey)
+			return nil
+		})
+		return err
+	}
+
+	for i := 0; i < maxMoveRetries; i++ {
+		err := rclient.Watch(ctx, move, oldKey, newKey)
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+		return err
+	}
+
+	return errors.New("actions are changed too often to move them")
+}
+
 func (a *AnalysisUser) Insert(entry AnalysisUser) error {
 	collection := mclient.Database("analysis").Collection("users")
 
 	_, err := collection.InsertOne(context.TODO(), AnalysisUser{
+		UserID:          entry.UserID,
 		Email:           entry.Email,
 		Actions:         entry.Actions,
 		PercentActivity: entry.PercentActivity,
@@ -218,6 +257,25 @@ func (a *AnalysisUser) GetOneByEmail(email string) (*AnalysisUser, error) {
 	return &entry, nil
 }
 
+func (a *AnalysisUser) GetOneByUserID(userID string) (*AnalysisUser, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	collection := mclient.Database("analysis").Collection("users")
+
+	var entry AnalysisUser
+	err := collection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&entry)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		} else {
+			return nil, err
+		}
+	}
+
+	return &entry, nil
+}
+
 func (a *AnalysisUser) DropCollection() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
@@ -260,3 +318,77 @@ func (a *AnalysisUser) Update() (*mongo.UpdateResult, error) {
 
 	return result, nil
 }
+
+func (a *AnalysisUser) DeleteByUser(userID, email string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	collection := mclient.Database("analysis").Collection("users")
+
+	filter := bson.M{"$or": bson.A{bson.M{"user_id": userID}, bson.M{"email": email}}}
+
+	_, err := collection.DeleteMany(ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Backfill merges documents counted by email into one document of user ID,
+// actions of both are summed and documents by email are deleted
+func (a *AnalysisUser) Backfill(userID, email string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	collection := mclient.Database("analysis").Collection("users")
+
+	cursor, err := collection.Find(ctx, bson.M{"email": email, "user_id": bson.M{"$exists": false}})
+	if err != nil {
+		return err
+	}
+
+	var entries []AnalysisUser
+	err = cursor.All(ctx, &entries)
+	if err != nil {
+		return err
+	}
+
+	if len(entries) == 0 {
+		return nil
+	}
+
+	var ids bson.A
+	var actions int
+	var percent float64
+	for _, entry := range entries {
+		docID, err := primitive.ObjectIDFromHex(entry.ID)
+		if err != nil {
+			return err
+		}
+
+		ids = append(ids, docID)
+		actions += entry.Actions
+		percent += entry.PercentActivity
+	}
+
+	_, err = collection.UpdateOne(ctx,
+		bson.M{"user_id": userID},
+		bson.M{
+			"$inc":         bson.M{"actions": actions, "percent_activity": percent},
+			"$set":         bson.M{"updated_at": time.Now()},
+			"$setOnInsert": bson.M{"created_at": time.Now()},
+		},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = collection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
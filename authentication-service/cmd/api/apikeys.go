@@ -0,0 +1,274 @@
+package main
+
+import (
+	"authentication/data"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// principal is client authenticated by session token or API key. Users scopes of principal
+// who is not admin are limited by broker to user`s own account
+type principal struct {
+	ID       int      `json:"id"`
+	UserID   string   `json:"user_id"`
+	Email    string   `json:"email"`
+	Admin    bool     `json:"admin"`
+	Scopes   []string `json:"scopes"`
+	APIKeyID int      `json:"api_key_id,omitempty"`
+}
+
+// CreateAPIKey creates API key with scopes for user of session, plain text key is returned only once
+func (app *Config) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		SessionToken string   `json:"session_token"`
+		Name         string   `json:"name"`
+		Scopes       []string `json:"scopes"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.userFromSession(requestPayload.SessionToken)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	requestPayload.Name = strings.TrimSpace(requestPayload.Name)
+	if requestPayload.Name == "" || len(requestPayload.Name) > 255 {
+		app.errorJSON(w, errors.New("name must be between 1 and 255 characters"), http.StatusBadRequest)
+		return
+	}
+
+	err = data.ValidateScopes(requestPayload.Scopes)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	// user can grant to API key only own scopes, so key can`t do more than its user
+	for _, scope := range requestPayload.Scopes {
+		if !data.HasScope(data.UserScopes(user.IsAdmin), scope) {
+			app.errorJSON(w, fmt.Errorf("scope %q can be granted only by admin", scope), http.StatusForbidden)
+			return
+		}
+	}
+
+	apiKey, err := app.Models.APIKey.New(user.ID, requestPayload.Name, requestPayload.Scopes)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	// log create api key without the key itself
+	go app.logRequest(user.PublicID, "create api key",
+		fmt.Sprintf("%s created api key %s with scopes %s", user.Email, apiKey.Prefix, strings.Join(apiKey.Scopes, " ")))
+	go app.auditRequest(user.PublicID, "create_api_key")
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "api key created, save it, it is shown only once",
+		Data:    apiKey,
+	}
+
+	app.writeJSON(w, http.StatusCreated, payload)
+}
+
+// GetAPIKeys returns API keys of user of session without plain text keys
+func (app *Config) GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		SessionToken string `json:"session_token"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.userFromSession(requestPayload.SessionToken)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	apiKeys, err := app.Models.APIKey.GetAllByUser(user.ID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("got %d api keys", len(apiKeys)),
+		Data:    apiKeys,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// RevokeAPIKey revokes API key of user of session
+func (app *Config) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		SessionToken string `json:"session_token"`
+		ID           int    `json:"id"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.userFromSession(requestPayload.SessionToken)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	err = app.Models.APIKey.Revoke(requestPayload.ID, user.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusNotFound)
+		return
+	}
+
+	// log revoke api key
+	go app.logRequest(user.PublicID, "revoke api key", fmt.Sprintf("%s revoked api key %d", user.Email, requestPayload.ID))
+	go app.auditRequest(user.PublicID, "revoke_api_key")
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "api key revoked",
+		Data:    requestPayload.ID,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// VerifyCredentials returns principal of API key, session token or stream token. Sessions and
+// streams have all scopes of user, API keys have only granted ones
+func (app *Config) VerifyCredentials(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		APIKey       string `json:"api_key"`
+		SessionToken string `json:"session_token"`
+		StreamToken  string `json:"stream_token"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	var p principal
+
+	switch {
+	case requestPayload.APIKey != "":
+		owner, err := app.Models.APIKey.GetOwner(requestPayload.APIKey)
+		if err != nil {
+			app.errorJSON(w, err, http.StatusUnauthorized)
+			return
+		}
+
+		go func() {
+			err := app.Models.APIKey.Touch(owner.KeyID)
+			if err != nil {
+				log.Println(err)
+			}
+		}()
+
+		p = principal{ID: owner.ID, UserID: owner.UserID, Email: owner.Email, Admin: owner.IsAdmin,
+			Scopes: owner.Scopes, APIKeyID: owner.KeyID}
+	case requestPayload.SessionToken != "":
+		user, err := app.userFromSession(requestPayload.SessionToken)
+		if err != nil {
+			app.errorJSON(w, err, http.StatusUnauthorized)
+			return
+		}
+
+		p = principal{ID: user.ID, UserID: user.PublicID, Email: user.Email, Admin: user.IsAdmin,
+			Scopes: data.UserScopes(user.IsAdmin)}
+	case requestPayload.StreamToken != "":
+		// stream token is single-use, so token leaked from URL can`t be replayed
+		userID, err := app.Models.Token.Consume(requestPayload.StreamToken, data.ScopeStream)
+		if err != nil {
+			app.errorJSON(w, err, http.StatusUnauthorized)
+			return
+		}
+
+		user, err := app.Models.User.GetOne(userID)
+		if err != nil || user.Active == 0 {
+			app.errorJSON(w, errors.New("invalid credentials"), http.StatusUnauthorized)
+			return
+		}
+
+		p = principal{ID: user.ID, UserID: user.PublicID, Email: user.Email, Admin: user.IsAdmin,
+			Scopes: data.UserScopes(user.IsAdmin)}
+	default:
+		app.errorJSON(w, errors.New("api key, session token or stream token is required"), http.StatusUnauthorized)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "credentials are valid",
+		Data:    p,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// userFromSession returns user of valid session token
+func (app *Config) userFromSession(sessionToken string) (*data.User, error) {
+	claims, err := app.Models.UserJWT.CheckJWTToken(sessionToken)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := app.Models.User.GetByEmail(claims.Email)
+	if err != nil {
+		return nil, errors.New("invalid credentials")
+	}
+
+	return user, nil
+}
+
+// CreateStreamToken returns short-lived single-use token of user authenticated by broker. Token is
+// used by clients of streams which can`t set headers, like EventSource
+func (app *Config) CreateStreamToken(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		ID int `json:"id"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.Models.User.GetOne(requestPayload.ID)
+	if err != nil || user.Active == 0 {
+		app.errorJSON(w, errors.New("invalid credentials"), http.StatusUnauthorized)
+		return
+	}
+
+	token, err := app.Models.Token.New(user.ID, data.ScopeStream, data.StreamTTL)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "stream token created",
+		Data:    token,
+	}
+
+	app.writeJSON(w, http.StatusCreated, payload)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"authentication/data"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// backfillURLs are endpoints of services which key their data on email of user
+var backfillURLs = []string{
+	"http://logger-service/backfill",
+	"http://analysis-service/backfill",
+}
+
+// backfill sends public ID of every user to logger and analysis services, so they key
+// data written before public IDs on it. Backfill can be run many times
+func backfill(models data.Models) error {
+	users, err := models.User.GetAllIdentities()
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+
+	for _, user := range users {
+		for _, url := range backfillURLs {
+			err = backfillRequest(url, user)
+			if err != nil {
+				log.Printf("error backfilling user with id %v: %v", user.ID, err)
+				failed++
+			}
+		}
+	}
+
+	log.Printf("Backfilled %d users", len(users))
+
+	if failed > 0 {
+		return fmt.Errorf("%d backfill requests failed", failed)
+	}
+
+	return nil
+}
+
+// backfillRequest requests service to key data of user`s email on user`s public ID
+func backfillRequest(url string, user *data.User) error {
+	var entry struct {
+		UserID string `json:"user_id"`
+		Email  string `json:"email"`
+	}
+
+	entry.UserID = user.PublicID
+	entry.Email = user.Email
+
+	jsonData, _ := json.MarshalIndent(entry, "", "\t")
+
+	request, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("error calling %s: status %d", url, response.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"authentication/data"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// importResult stores result of import of one row
+type importResult struct {
+	Row    int      `json:"row"`
+	Email  string   `json:"email"`
+	Status string   `json:"status"`
+	ID     int      `json:"id,omitempty"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+// exportedUser stores exported fields of user, password and internal fields are never exported
+type exportedUser struct {
+	ID        int       `json:"id"`
+	PublicID  string    `json:"public_id"`
+	Email     string    `json:"email"`
+	FirstName string    `json:"first_name"`
+	LastName  string    `json:"last_name"`
+	Active    int       `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ImportUsers creates users from CSV or JSON. Every row is validated and imported separately,
+// so invalid rows don`t stop import of valid ones. Dry run only validates rows
+func (app *Config) ImportUsers(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Format string `json:"format"`
+		Data   string `json:"data"`
+		DryRun bool   `json:"dry_run"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	rows, err := data.ParseImport(requestPayload.Format, requestPayload.Data)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	results := make([]importResult, 0, len(rows))
+	seen := make(map[string]bool, len(rows))
+	imported, failed := 0, 0
+
+	for _, row := range rows {
+		result := importResult{Row: row.Row, Email: row.Email}
+
+		problems := app.validateImportRow(row, seen)
+
+		switch {
+		case len(problems) > 0:
+			result.Status = "invalid"
+			result.Errors = problems
+			failed++
+		case requestPayload.DryRun:
+			result.Status = "valid"
+			imported++
+		default:
+			id, err := app.importUser(row)
+			if err != nil {
+				result.Status = "failed"
+				result.Errors = []string{err.Error()}
+				failed++
+			} else {
+				result.Status = "created"
+				result.ID = id
+				imported++
+			}
+		}
+
+		results = append(results, result)
+	}
+
+	message := fmt.Sprintf("imported %d users, %d failed", imported, failed)
+	if requestPayload.DryRun {
+		message = fmt.Sprintf("dry run: %d users are valid, %d are invalid", imported, failed)
+	} else {
+		// log import
+		go app.logRequest("", "import users", message)
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: message,
+		Data: struct {
+			DryRun   bool           `json:"dry_run"`
+			Total    int            `json:"total"`
+			Imported int            `json:"imported"`
+			Failed   int            `json:"failed"`
+			Results  []importResult `json:"results"`
+		}{
+			DryRun:   requestPayload.DryRun,
+			Total:    len(rows),
+			Imported: imported,
+			Failed:   failed,
+			Results:  results,
+		},
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// validateImportRow returns all problems of row, seen stores emails of previous rows of the same import
+func (app *Config) validateImportRow(row data.ImportRow, seen map[string]bool) []string {
+	var problems []string
+
+	address, err := mail.ParseAddress(row.Email)
+	if err != nil || address.Address != row.Email {
+		problems = append(problems, "email is invalid")
+	} else {
+		email := strings.ToLower(row.Email)
+		if seen[email] {
+			problems = append(problems, "email is repeated in import")
+		}
+		seen[email] = true
+
+		exists, err := app.Models.User.EmailExists(row.Email)
+		if err != nil {
+			problems = append(problems, err.Error())
+		} else if exists {
+			problems = append(problems, data.ErrDuplicateEmail.Error())
+		}
+	}
+
+	switch {
+	case row.Password != "" && row.PasswordHash != "":
+		problems = append(problems, "only one of password and password_hash must be set")
+	case row.Password != "":
+		err = app.Models.PasswordPolicy.Validate(row.Password, row.Email)
+		var validationErr *data.ValidationError
+		if errors.As(err, &validationErr) {
+			problems = append(problems, validationErr.Problems...)
+		} else if err != nil {
+			problems = append(problems, err.Error())
+		}
+	case row.PasswordHash != "":
+		err = data.ValidatePasswordHash(row.PasswordHash)
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+	default:
+		problems = append(problems, "password or password_hash is required")
+	}
+
+	if row.Active != nil && *row.Active != 0 && *row.Active != 1 {
+		problems = append(problems, "active must be 0 or 1")
+	}
+
+	return problems
+}
+
+// importUser inserts valid row. Imported users are active by default, because their emails
+// were verified by the system they come from
+func (app *Config) importUser(row data.ImportRow) (int, error) {
+	user := data.User{
+		Email:         row.Email,
+		FirstName:     row.FirstName,
+		LastName:      row.LastName,
+		Password:      row.Password,
+		Active:        1,
+		EmailVerified: true,
+	}
+
+	if row.Active != nil {
+		user.Active = *row.Active
+	}
+
+	var id int
+	var err error
+
+	if row.PasswordHash != "" {
+		id, err = app.Models.User.InsertWithHash(user, row.PasswordHash)
+	} else {
+		id, err = app.Models.User.Insert(user)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	created, err := app.Models.User.GetOne(id)
+	if err == nil {
+		// analysis action
+		go app.analysisRequest(created.PublicID)
+	}
+
+	return id, nil
+}
+
+// ExportUsers streams all not deleted users as CSV or JSON array without passwords
+func (app *Config) ExportUsers(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+
+	if format != "csv" && format != "json" {
+		app.errorJSON(w, fmt.Errorf("unknown format %q, format must be csv or json", format), http.StatusBadRequest)
+		return
+	}
+
+	fileName := fmt.Sprintf("users-%s.%s", time.Now().UTC().Format("20060102T150405Z"), format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName))
+
+	var err error
+	var count int
+
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		count, err = app.exportCSV(w)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		count, err = app.exportJSON(w)
+	}
+
+	// status is already sent, so error can only be logged
+	if err != nil {
+		log.Println("error exporting users:", err)
+		return
+	}
+
+	// log export
+	go app.logRequest("", "export users", fmt.Sprintf("exported %d users as %s", count, format))
+}
+
+func (app *Config) exportCSV(w http.ResponseWriter) (int, error) {
+	writer := csv.NewWriter(w)
+
+	err := writer.Write([]string{"id", "public_id", "email", "first_name", "last_name", "active", "created_at", "updated_at"})
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+
+	err = app.Models.User.Export(func(user *data.User) error {
+		err := writer.Write([]string{
+			strconv.Itoa(user.ID),
+			user.PublicID,
+			csvCell(user.Email),
+			csvCell(user.FirstName),
+			csvCell(user.LastName),
+			strconv.Itoa(user.Active),
+			user.CreatedAt.UTC().Format(time.RFC3339),
+			user.UpdatedAt.UTC().Format(time.RFC3339),
+		})
+		if err != nil {
+			return err
+		}
+
+		count++
+		if count%100 == 0 {
+			writer.Flush()
+			flush(w)
+		}
+
+		return writer.Error()
+	})
+	if err != nil {
+		return count, err
+	}
+
+	writer.Flush()
+
+	return count, writer.Error()
+}
+
+func (app *Config) exportJSON(w http.ResponseWriter) (int, error) {
+	_, err := w.Write([]byte("["))
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+
+	err = app.Models.User.Export(func(user *data.User) error {
+		if count > 0 {
+			_, err := w.Write([]byte(","))
+			if err != nil {
+				return err
+			}
+		}
+
+		out, err := json.Marshal(exportedUser{
+			ID:        user.ID,
+			PublicID:  user.PublicID,
+			Email:     user.Email,
+			FirstName: user.FirstName,
+			LastName:  user.LastName,
+			Active:    user.Active,
+			CreatedAt: user.CreatedAt,
+			UpdatedAt: user.UpdatedAt,
+		})
+		if err != nil {
+			return err
+		}
+
+		_, err = w.Write(out)
+		if err != nil {
+			return err
+		}
+
+		count++
+		if count%100 == 0 {
+			flush(w)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return count, err
+	}
+
+	_, err = w.Write([]byte("]"))
+
+	return count, err
+}
+
+// flush sends written part of response to client
+func flush(w http.ResponseWriter) {
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// csvCell escapes cell which spreadsheet would run as formula, so user`s names can`t inject formulas
+func csvCell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@", rune(value[0])) {
+		return "'" + value
+	}
+
+	return value
+}
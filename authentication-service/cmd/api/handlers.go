@@ -3,11 +3,15 @@ package main
 import (
 	"authentication/data"
 	"bytes"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -31,7 +35,7 @@ func (app *Config) GetByEmail(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// log getByEmail
-	go app.logRequest("receive user", fmt.Sprintf("%s received", user.Email))
+	go app.logRequest(user.PublicID, "receive user", fmt.Sprintf("%s received", user.Email))
 
 	payload := jsonResponse{
 		Error:   false,
@@ -52,6 +56,15 @@ func (app *Config) Registration(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	err = app.Models.PasswordPolicy.Validate(requestPayload.Password, requestPayload.Email)
+	if err != nil {
+		app.validationErrorJSON(w, err)
+		return
+	}
+
+	// user stays inactive until email is verified
+	requestPayload.Active = 0
+
 	// insert user in database
 	id, err := app.Models.User.Insert(requestPayload)
 	if err != nil {
@@ -59,37 +72,77 @@ func (app *Config) Registration(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	user, err := app.Models.User.GetOne(id)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	verificationToken, err := app.Models.Token.CreateVerificationToken(id, requestPayload.Email)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	// send verification email
+	go app.mailRequest(requestPayload.Email, "Verify your email", "verify_email", map[string]any{
+		"name":  requestPayload.FirstName,
+		"token": verificationToken,
+		"link":  fmt.Sprintf("%s/verify_email?token=%s", app.FrontEndURL, verificationToken),
+	})
+
 	// log registration
-	go app.logRequest("registered", fmt.Sprintf("%s registered in", requestPayload.Email))
+	go app.logRequest(user.PublicID, "registered", fmt.Sprintf("%s registered in", requestPayload.Email))
 
 	// analysis action
-	go app.analysisRequest(requestPayload.Email)
+	go app.analysisRequest(user.PublicID)
 
 	payload := jsonResponse{
 		Error:   false,
-		Message: fmt.Sprintf("Created user with id %v", id),
+		Message: fmt.Sprintf("Created user with id %v, verification email sent", id),
 		Data:    id,
 	}
 
 	app.writeJSON(w, http.StatusCreated, payload)
 }
 
-// GetAll returns all users
+// GetAll returns page of users filtered and sorted by request
 func (app *Config) GetAll(w http.ResponseWriter, r *http.Request) {
-	// get all users from database
-	users, err := app.Models.User.GetAll()
+	var filter data.UserFilter
+
+	// empty body means the first page with default sort order
+	err := app.readJSON(w, r, &filter)
+	if err != nil && !errors.Is(err, io.EOF) {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	err = filter.Validate()
 	if err != nil {
-		app.errorJSON(w, errors.New("invalid credentials"), http.StatusBadRequest)
+		app.errorJSON(w, err, http.StatusUnprocessableEntity)
+		return
+	}
+
+	// get page of users from database
+	users, metadata, err := app.Models.User.GetAll(filter)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
 		return
 	}
 
 	// log getAll
-	go app.logRequest("receive users", fmt.Sprintf("received %v users", len(users)))
+	go app.logRequest("", "receive users", fmt.Sprintf("received %v of %v users", len(users), metadata.TotalRecords))
 
 	payload := jsonResponse{
 		Error:   false,
-		Message: fmt.Sprintf("Received %v users", len(users)),
-		Data:    users,
+		Message: fmt.Sprintf("Received %v of %v users", len(users), metadata.TotalRecords),
+		Data: struct {
+			Users    []*data.User  `json:"users"`
+			Metadata data.Metadata `json:"metadata"`
+		}{
+			Users:    users,
+			Metadata: metadata,
+		},
 	}
 
 	app.writeJSON(w, http.StatusOK, payload)
@@ -100,6 +153,7 @@ func (app *Config) Authenticate(w http.ResponseWriter, r *http.Request) {
 	var requestPayload struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
+		IP       string `json:"ip,omitempty"`
 	}
 
 	err := app.readJSON(w, r, &requestPayload)
@@ -108,34 +162,190 @@ func (app *Config) Authenticate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// check lockout and delay after previous failed attempts
+	wait, err := app.Models.LoginAttempt.Check(requestPayload.Email, requestPayload.IP)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if wait > 0 {
+		app.errorJSON(w, fmt.Errorf("too many failed login attempts, try again in %v", wait.Round(time.Second)),
+			http.StatusTooManyRequests)
+		return
+	}
+
 	// validate the user against the database
 	user, err := app.Models.User.GetByEmailWithPassword(requestPayload.Email)
 	if err != nil {
+		app.loginFailed(requestPayload.Email, requestPayload.IP)
 		app.errorJSON(w, errors.New("invalid credentials"), http.StatusBadRequest)
 		return
 	}
 
 	valid, err := user.PasswordMatches(requestPayload.Password)
 	if err != nil || !valid {
+		app.loginFailed(requestPayload.Email, requestPayload.IP)
+		app.errorJSON(w, errors.New("invalid credentials"), http.StatusBadRequest)
+		return
+	}
+
+	if user.Active == 0 {
+		err = errors.New("email is not verified")
+		if user.EmailVerified {
+			err = ErrUserDisabled
+		}
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	app.completeLogin(w, user)
+}
+
+// completeLogin issues session token for authenticated user or two-factor challenge if it is enabled
+func (app *Config) completeLogin(w http.ResponseWriter, user *data.User) {
+	// session token is issued only after second step if two-factor authentication is enabled
+	if user.TwoFactorEnabled {
+		challenge, err := app.Models.TwoFactor.CreateChallenge(user.ID)
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+
+		payload := jsonResponse{
+			Error:   false,
+			Message: "two-factor authentication required",
+			Data: struct {
+				TwoFactorRequired bool   `json:"two_factor_required"`
+				Challenge         string `json:"challenge"`
+			}{
+				TwoFactorRequired: true,
+				Challenge:         challenge,
+			},
+		}
+
+		app.writeJSON(w, http.StatusOK, payload)
+		return
+	}
+
+	app.issueSession(w, user)
+}
+
+// Authenticate2FA completes two-step login with TOTP or recovery code and issues session token
+func (app *Config) Authenticate2FA(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Challenge string `json:"challenge"`
+		Code      string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	// every try is counted before code is checked, so parallel tries can`t exceed limit of challenge
+	userID, err := app.Models.TwoFactor.TryChallenge(requestPayload.Challenge)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.Models.User.GetOneWithPassword(userID)
+	if err != nil {
 		app.errorJSON(w, errors.New("invalid credentials"), http.StatusBadRequest)
 		return
 	}
 
-	jwtToken, err := app.Models.UserJWT.CreateJWTToken(requestPayload.Email)
+	// wrong codes are counted per account like wrong passwords, so new challenges don`t give new tries
+	wait, err := app.Models.LoginAttempt.Check(user.Email, "")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if wait > 0 {
+		app.errorJSON(w, fmt.Errorf("too many failed login attempts, try again in %v", wait.Round(time.Second)),
+			http.StatusTooManyRequests)
+		return
+	}
+
+	valid, err := app.checkSecondFactor(user.ID, requestPayload.Code)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if !valid {
+		app.loginFailed(user.Email, "")
+		app.errorJSON(w, errors.New("invalid two-factor code"), http.StatusBadRequest)
+		return
+	}
+
+	err = app.Models.TwoFactor.DeleteChallenge(requestPayload.Challenge)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.issueSession(w, user)
+}
+
+// checkSecondFactor checks TOTP code or recovery code of user
+func (app *Config) checkSecondFactor(userID int, code string) (bool, error) {
+	secret, enabled, err := app.Models.TwoFactor.GetSecret(userID)
+	if err != nil {
+		return false, err
+	}
+
+	if !enabled {
+		return false, nil
+	}
+
+	valid, err := app.Models.TwoFactor.ValidateCode(userID, secret, code)
+	if err != nil || valid {
+		return valid, err
+	}
+
+	used, err := app.Models.TwoFactor.UseRecoveryCode(userID, code)
+	if err != nil {
+		return false, err
+	}
+
+	if used {
+		// log recovery code
+		go app.logRequest(app.publicID(userID), "recovery code", fmt.Sprintf("user with id %v used recovery code", userID))
+	}
+
+	return used, nil
+}
+
+// issueSession creates session token for authenticated user and writes user without password.
+// Failed attempts are cleared only here, so after second factor if it is enabled
+func (app *Config) issueSession(w http.ResponseWriter, user *data.User) {
+	err := app.Models.LoginAttempt.Reset(user.Email)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	jwtToken, err := app.Models.UserJWT.CreateJWTToken(user)
 	if err != nil {
 		app.errorJSON(w, err, http.StatusBadRequest)
 		return
 	}
 
 	// log authentication
-	go app.logRequest("authentication", fmt.Sprintf("%s logged in", user.Email))
+	go app.logRequest(user.PublicID, "authentication", fmt.Sprintf("%s logged in", user.Email))
+	go app.auditRequest(user.PublicID, "login")
 
 	// analysis action
-	go app.analysisRequest(requestPayload.Email)
+	go app.analysisRequest(user.PublicID)
 
 	// structure for response without password
 	u := struct {
 		ID           int       `json:"id"`
+		PublicID     string    `json:"public_id"`
 		Email        string    `json:"email"`
 		FirstName    string    `json:"first_name,omitempty"`
 		LastName     string    `json:"last_name,omitempty"`
@@ -146,6 +356,7 @@ func (app *Config) Authenticate(w http.ResponseWriter, r *http.Request) {
 	}{}
 
 	u.ID = user.ID
+	u.PublicID = user.PublicID
 	u.Email = user.Email
 	u.FirstName = user.FirstName
 	u.LastName = user.LastName
@@ -163,10 +374,10 @@ func (app *Config) Authenticate(w http.ResponseWriter, r *http.Request) {
 	app.writeJSON(w, http.StatusOK, payload)
 }
 
-// GetByID returns user by ID
-func (app *Config) GetByID(w http.ResponseWriter, r *http.Request) {
+// EnrollTwoFactor generates TOTP secret for user, it must be confirmed with ConfirmTwoFactor
+func (app *Config) EnrollTwoFactor(w http.ResponseWriter, r *http.Request) {
 	var requestPayload struct {
-		ID int `json:"id"`
+		SessionToken string `json:"session_token"`
 	}
 
 	err := app.readJSON(w, r, &requestPayload)
@@ -175,33 +386,52 @@ func (app *Config) GetByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// validate the user against the database
-	user, err := app.Models.User.GetOne(requestPayload.ID)
+	claims, err := app.Models.UserJWT.CheckJWTToken(requestPayload.SessionToken)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.Models.User.GetByEmailWithPassword(claims.Email)
 	if err != nil {
 		app.errorJSON(w, errors.New("invalid credentials"), http.StatusBadRequest)
 		return
 	}
 
-	// log get by id
-	go app.logRequest("receive user", fmt.Sprintf("%s received", user.Email))
+	if user.TwoFactorEnabled {
+		app.errorJSON(w, errors.New("two-factor authentication is already enabled"), http.StatusBadRequest)
+		return
+	}
+
+	twoFactor, err := app.Models.TwoFactor.GenerateSecret(user.Email)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.Models.TwoFactor.SetPendingSecret(user.ID, twoFactor.Secret)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	// log enroll 2fa
+	go app.logRequest(user.PublicID, "enroll 2fa", fmt.Sprintf("%s started two-factor enrollment", user.Email))
 
 	payload := jsonResponse{
 		Error:   false,
-		Message: fmt.Sprintf("received user"),
-		Data:    user,
+		Message: "scan otpauth uri and confirm it with code",
+		Data:    twoFactor,
 	}
 
 	app.writeJSON(w, http.StatusOK, payload)
 }
 
-// Update updates user`s fields
-func (app *Config) Update(w http.ResponseWriter, r *http.Request) {
+// ConfirmTwoFactor enables two-factor authentication by the first TOTP code and returns recovery codes
+func (app *Config) ConfirmTwoFactor(w http.ResponseWriter, r *http.Request) {
 	var requestPayload struct {
-		Email       string `json:"email"`
-		EmailChange string `json:"email_change"`
-		FirstName   string `json:"first_name,omitempty,omitempty"`
-		LastName    string `json:"last_name,omitempty,omitempty"`
-		Active      int    `json:"active,omitempty"`
+		SessionToken string `json:"session_token"`
+		Code         string `json:"code"`
 	}
 
 	err := app.readJSON(w, r, &requestPayload)
@@ -210,101 +440,93 @@ func (app *Config) Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// get user from database
-	user, err := app.Models.User.GetByEmail(requestPayload.Email)
+	claims, err := app.Models.UserJWT.CheckJWTToken(requestPayload.SessionToken)
 	if err != nil {
-		app.errorJSON(w, errors.New("invalid credentials"), http.StatusBadRequest)
+		app.errorJSON(w, err, http.StatusBadRequest)
 		return
 	}
 
-	// check updated objects
-	if requestPayload.EmailChange != "" {
-		user.Email = requestPayload.EmailChange
-	}
-	if requestPayload.Active != user.Active {
-		user.Active = requestPayload.Active
-	}
-	if requestPayload.FirstName != "" {
-		user.FirstName = requestPayload.FirstName
-	}
-	if requestPayload.LastName != "" {
-		user.LastName = requestPayload.LastName
+	user, err := app.Models.User.GetByEmail(claims.Email)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid credentials"), http.StatusBadRequest)
+		return
 	}
 
-	// update user
-	err = user.Update()
+	secret, enabled, err := app.Models.TwoFactor.GetSecret(user.ID)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
-	// log update
-	go app.logRequest("update", fmt.Sprintf("%s updated, now %s", requestPayload.Email, user.Email))
-
-	// analysis action
-	go app.analysisRequest(requestPayload.Email)
-
-	payload := jsonResponse{
-		Error:   false,
-		Message: fmt.Sprintf("Updated user with id %v", user.ID),
-		Data:    user.Email,
+	if enabled {
+		app.errorJSON(w, errors.New("two-factor authentication is already enabled"), http.StatusBadRequest)
+		return
 	}
 
-	app.writeJSON(w, http.StatusOK, payload)
-}
-
-// ChangePassword changes user`s password
-func (app *Config) ChangePassword(w http.ResponseWriter, r *http.Request) {
-	var requestPayload struct {
-		Email       string `json:"email"`
-		Password    string `json:"password"`
-		NewPassword string `json:"new_password"`
+	if secret == "" {
+		app.errorJSON(w, errors.New("two-factor enrollment is not started"), http.StatusBadRequest)
+		return
 	}
 
-	err := app.readJSON(w, r, &requestPayload)
+	valid, err := app.Models.TwoFactor.ValidateCode(user.ID, secret, requestPayload.Code)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, err)
 		return
 	}
 
-	// get user from database
-	user, err := app.Models.User.GetByEmailWithPassword(requestPayload.Email)
-	if err != nil {
-		app.errorJSON(w, errors.New("invalid credentials"), http.StatusBadRequest)
+	if !valid {
+		app.errorJSON(w, errors.New("invalid two-factor code"), http.StatusBadRequest)
 		return
 	}
 
-	// check user`s password
-	valid, err := user.PasswordMatches(requestPayload.Password)
-	if err != nil || !valid {
-		app.errorJSON(w, errors.New("invalid credentials"), http.StatusBadRequest)
+	codes, err := app.Models.TwoFactor.CreateRecoveryCodes(user.ID)
+	if err != nil {
+		app.errorJSON(w, err)
 		return
 	}
 
-	// update user`s password
-	err = user.ResetPassword(requestPayload.NewPassword)
+	err = app.Models.TwoFactor.Enable(user.ID)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
-	// log change password
-	go app.logRequest("change password", fmt.Sprintf("%s changed password", requestPayload.Email))
-
-	// analysis action
-	go app.analysisRequest(user.Email)
+	// log confirm 2fa
+	go app.logRequest(user.PublicID, "enable 2fa", fmt.Sprintf("%s enabled two-factor authentication", user.Email))
+	go app.auditRequest(user.PublicID, "enable_2fa")
 
 	payload := jsonResponse{
 		Error:   false,
-		Message: fmt.Sprintf("Changed user`s password with id %v", user.ID),
-		Data:    user.Email,
+		Message: "two-factor authentication enabled, save recovery codes",
+		Data: struct {
+			RecoveryCodes []string `json:"recovery_codes"`
+		}{
+			RecoveryCodes: codes,
+		},
 	}
 
 	app.writeJSON(w, http.StatusOK, payload)
 }
 
-// DeleteByEmail deletes user by email
-func (app *Config) DeleteByEmail(w http.ResponseWriter, r *http.Request) {
+// loginFailed counts failed login attempt and logs lockout
+func (app *Config) loginFailed(email, ip string) {
+	locked, err := app.Models.LoginAttempt.Fail(email, ip)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	if locked {
+		userID := app.publicIDByEmail(email)
+
+		// log lockout
+		go app.logRequest(userID, "lockout", fmt.Sprintf("%s locked out after failed login attempts from %s", email, ip))
+		go app.auditRequest(userID, "lockout")
+	}
+}
+
+// UnlockUser removes lockout of user after failed login attempts
+func (app *Config) UnlockUser(w http.ResponseWriter, r *http.Request) {
 	var requestPayload struct {
 		Email string `json:"email"`
 	}
@@ -315,33 +537,40 @@ func (app *Config) DeleteByEmail(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// get user form database
-	user, err := app.Models.User.GetByEmail(requestPayload.Email)
+	locked, err := app.Models.LoginAttempt.IsLocked(requestPayload.Email)
 	if err != nil {
-		app.errorJSON(w, errors.New("invalid credentials"), http.StatusBadRequest)
+		app.errorJSON(w, err)
 		return
 	}
 
-	err = user.Delete()
+	err = app.Models.LoginAttempt.Unlock(requestPayload.Email)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
-	// log delete by email
-	go app.logRequest("delete user", fmt.Sprintf("%s deleted", requestPayload.Email))
+	message := fmt.Sprintf("%s was not locked", requestPayload.Email)
+	if locked {
+		message = fmt.Sprintf("unlocked %s", requestPayload.Email)
+
+		userID := app.publicIDByEmail(requestPayload.Email)
+
+		// log unlock
+		go app.logRequest(userID, "unlock", fmt.Sprintf("%s unlocked", requestPayload.Email))
+		go app.auditRequest(userID, "unlock")
+	}
 
 	payload := jsonResponse{
 		Error:   false,
-		Message: fmt.Sprintf("user deleted"),
-		Data:    "",
+		Message: message,
+		Data:    requestPayload.Email,
 	}
 
 	app.writeJSON(w, http.StatusOK, payload)
 }
 
-// DeleteByID deletes user by ID
-func (app *Config) DeleteByID(w http.ResponseWriter, r *http.Request) {
+// GetByID returns user by ID
+func (app *Config) GetByID(w http.ResponseWriter, r *http.Request) {
 	var requestPayload struct {
 		ID int `json:"id"`
 	}
@@ -353,28 +582,34 @@ func (app *Config) DeleteByID(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// validate the user against the database
-	err = app.Models.User.DeleteByID(requestPayload.ID)
+	user, err := app.Models.User.GetOne(requestPayload.ID)
 	if err != nil {
-		app.errorJSON(w, err)
+		app.errorJSON(w, errors.New("invalid credentials"), http.StatusBadRequest)
 		return
 	}
 
-	// log delete by id
-	go app.logRequest("delete user", fmt.Sprintf("user with id %v deleted", requestPayload.ID))
+	// log get by id
+	go app.logRequest(user.PublicID, "receive user", fmt.Sprintf("%s received", user.Email))
 
 	payload := jsonResponse{
 		Error:   false,
-		Message: fmt.Sprintf("deleted user"),
-		Data:    "",
+		Message: fmt.Sprintf("received user"),
+		Data:    user,
 	}
 
 	app.writeJSON(w, http.StatusOK, payload)
 }
 
-// AuthenticateSession checks valid or not session of user
-func (app *Config) AuthenticateSession(w http.ResponseWriter, r *http.Request) {
+// Update partially updates user`s fields. Omitted or null fields are not changed,
+// empty first_name or last_name clears it
+func (app *Config) Update(w http.ResponseWriter, r *http.Request) {
 	var requestPayload struct {
-		SessionToken string `json:"session_token"`
+		Email       string  `json:"email"`
+		EmailChange *string `json:"email_change,omitempty"`
+		FirstName   *string `json:"first_name,omitempty"`
+		LastName    *string `json:"last_name,omitempty"`
+		Active      *int    `json:"active,omitempty"`
+		Version     *int    `json:"version,omitempty"`
 	}
 
 	err := app.readJSON(w, r, &requestPayload)
@@ -383,36 +618,631 @@ func (app *Config) AuthenticateSession(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	email, err := app.Models.UserJWT.CheckJWTToken(requestPayload.SessionToken)
-	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
-		return
+	// If-Match header is the same precondition as version field
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && requestPayload.Version == nil {
+		version, err := strconv.Atoi(strings.Trim(ifMatch, `"`))
+		if err != nil {
+			app.errorJSON(w, errors.New("invalid If-Match header"), http.StatusBadRequest)
+			return
+		}
+		requestPayload.Version = &version
 	}
 
-	// log session
-	go app.logRequest("checked users session", fmt.Sprintf("%s`s session is valid", email))
+	if requestPayload.Active != nil && *requestPayload.Active != 0 && *requestPayload.Active != 1 {
+		app.errorJSON(w, errors.New("active must be 0 or 1"), http.StatusUnprocessableEntity)
+		return
+	}
 
-	// analysis action
-	go app.analysisRequest(email)
+	if requestPayload.EmailChange != nil && *requestPayload.EmailChange == "" {
+		app.errorJSON(w, errors.New("email must not be empty"), http.StatusUnprocessableEntity)
+		return
+	}
 
-	payload := jsonResponse{
-		Error:   false,
-		Message: fmt.Sprintf("session is valid"),
-		Data:    email,
+	// get user from database
+	user, err := app.Models.User.GetByEmail(requestPayload.Email)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid credentials"), http.StatusBadRequest)
+		return
 	}
 
-	app.writeJSON(w, http.StatusOK, payload)
-}
+	// reject changes based on stale user
+	if requestPayload.Version != nil && *requestPayload.Version != user.Version {
+		app.errorJSON(w, data.ErrEditConflict, http.StatusConflict)
+		return
+	}
 
-// logRequest requests of logger-service to log event
-func (app *Config) logRequest(name, data string) {
-	var entry struct {
-		Name string `json:"name"`
-		Data string `json:"data"`
+	// check updated objects
+	if requestPayload.Active != nil {
+		user.Active = *requestPayload.Active
+	}
+	if requestPayload.FirstName != nil {
+		user.FirstName = *requestPayload.FirstName
+	}
+	if requestPayload.LastName != nil {
+		user.LastName = *requestPayload.LastName
 	}
 
-	entry.Name = name
-	entry.Data = data
+	// update user
+	err = user.Update()
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict), errors.Is(err, data.ErrDuplicateEmail):
+			app.errorJSON(w, err, http.StatusConflict)
+		default:
+			app.errorJSON(w, err)
+		}
+		return
+	}
+
+	message := fmt.Sprintf("Updated user with id %v", user.ID)
+
+	// email is changed only after confirmation from new address
+	if requestPayload.EmailChange != nil && !strings.EqualFold(*requestPayload.EmailChange, user.Email) {
+		err = app.requestEmailChange(user, *requestPayload.EmailChange)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrDuplicateEmail):
+				app.errorJSON(w, err, http.StatusConflict)
+			default:
+				app.errorJSON(w, err)
+			}
+			return
+		}
+
+		message += ", confirmation email sent to new address"
+	}
+
+	// log update
+	go app.logRequest(user.PublicID, "update", fmt.Sprintf("%s updated", requestPayload.Email))
+
+	// analysis action
+	go app.analysisRequest(user.PublicID)
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: message,
+		Data:    user,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// requestEmailChange creates pending email change and sends confirmation link to new address
+// and notice to old one
+func (app *Config) requestEmailChange(user *data.User, newEmail string) error {
+	_, err := app.Models.User.GetByEmail(newEmail)
+	if err == nil {
+		return data.ErrDuplicateEmail
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	token, err := app.Models.EmailChange.Create(user.ID, newEmail)
+	if err != nil {
+		return err
+	}
+
+	go app.mailRequest(newEmail, "Confirm your new email", "confirm_email_change", map[string]any{
+		"name":  user.FirstName,
+		"token": token,
+		"link":  fmt.Sprintf("%s/confirm_email_change?token=%s", app.FrontEndURL, token),
+	})
+
+	go app.mailRequest(user.Email, "Email change requested", "email_change_notice", map[string]any{
+		"name":      user.FirstName,
+		"new_email": newEmail,
+	})
+
+	return nil
+}
+
+// ConfirmEmailChange changes email of user by confirmation token from new address
+func (app *Config) ConfirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Token string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	change, err := app.Models.EmailChange.Confirm(requestPayload.Token)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrInvalidToken):
+			app.errorJSON(w, err, http.StatusBadRequest)
+		case errors.Is(err, data.ErrDuplicateEmail):
+			app.errorJSON(w, err, http.StatusConflict)
+		default:
+			app.errorJSON(w, err)
+		}
+		return
+	}
+
+	// log confirm email change
+	go app.logRequest(change.PublicID, "email changed", fmt.Sprintf("%s changed email to %s", change.OldEmail, change.NewEmail))
+	go app.auditRequest(change.PublicID, "email_change")
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("changed email of user with id %v", change.UserID),
+		Data:    change.NewEmail,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// ChangePassword changes user`s password
+func (app *Config) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Email       string `json:"email"`
+		Password    string `json:"password"`
+		NewPassword string `json:"new_password"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	// get user from database
+	user, err := app.Models.User.GetByEmailWithPassword(requestPayload.Email)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid credentials"), http.StatusBadRequest)
+		return
+	}
+
+	// check user`s password
+	valid, err := user.PasswordMatches(requestPayload.Password)
+	if err != nil || !valid {
+		app.errorJSON(w, errors.New("invalid credentials"), http.StatusBadRequest)
+		return
+	}
+
+	err = app.Models.PasswordPolicy.Validate(requestPayload.NewPassword, user.Email)
+	if err != nil {
+		app.validationErrorJSON(w, err)
+		return
+	}
+
+	// update user`s password
+	err = user.ResetPassword(requestPayload.NewPassword)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	// log change password
+	go app.logRequest(user.PublicID, "change password", fmt.Sprintf("%s changed password", requestPayload.Email))
+	go app.auditRequest(user.PublicID, "password_change")
+
+	// analysis action
+	go app.analysisRequest(user.PublicID)
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Changed user`s password with id %v", user.ID),
+		Data:    user.Email,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// RequestPasswordReset sends email with one-time link to reset user`s password
+func (app *Config) RequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	// the same response is returned whether user exists or not
+	payload := jsonResponse{
+		Error:   false,
+		Message: "if user exists, email with reset link was sent",
+	}
+
+	// get user from database
+	user, err := app.Models.User.GetByEmail(requestPayload.Email)
+	if err != nil {
+		app.writeJSON(w, http.StatusOK, payload)
+		return
+	}
+
+	// only the last requested link is valid
+	err = app.Models.Token.DeleteAllForUser(user.ID, data.ScopePasswordReset)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	resetToken, err := app.Models.Token.New(user.ID, data.ScopePasswordReset, data.PasswordResetTTL)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	// send reset email
+	go app.mailRequest(user.Email, "Reset your password", "password_reset", map[string]any{
+		"name":  user.FirstName,
+		"token": resetToken,
+		"link":  fmt.Sprintf("%s/reset_password?token=%s", app.FrontEndURL, resetToken),
+	})
+
+	// log request password reset
+	go app.logRequest(user.PublicID, "request password reset", fmt.Sprintf("%s requested password reset", user.Email))
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// ConfirmPasswordReset sets new user`s password by reset token and revokes all user`s sessions
+func (app *Config) ConfirmPasswordReset(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Token       string `json:"token"`
+		NewPassword string `json:"new_password"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	// token is consumed only when new password is valid
+	userID, err := app.Models.Token.GetUserID(requestPayload.Token, data.ScopePasswordReset)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.Models.User.GetOne(userID)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid credentials"), http.StatusBadRequest)
+		return
+	}
+
+	err = app.Models.PasswordPolicy.Validate(requestPayload.NewPassword, user.Email)
+	if err != nil {
+		app.validationErrorJSON(w, err)
+		return
+	}
+
+	// consume single-use token
+	_, err = app.Models.Token.Consume(requestPayload.Token, data.ScopePasswordReset)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	// update user`s password
+	err = user.ResetPassword(requestPayload.NewPassword)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.Models.Token.DeleteAllForUser(user.ID, data.ScopePasswordReset)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = user.RevokeSessions()
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	// log confirm password reset
+	go app.logRequest(user.PublicID, "reset password", fmt.Sprintf("%s reset password", user.Email))
+	go app.auditRequest(user.PublicID, "password_reset")
+
+	// analysis action
+	go app.analysisRequest(user.PublicID)
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Reset user`s password with id %v", user.ID),
+		Data:    user.Email,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// DeleteByEmail deletes user by email
+func (app *Config) DeleteByEmail(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	// get user form database
+	user, err := app.Models.User.GetByEmail(requestPayload.Email)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid credentials"), http.StatusBadRequest)
+		return
+	}
+
+	err = user.Delete()
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	// log delete by email
+	go app.logRequest(user.PublicID, "delete user", fmt.Sprintf("%s deleted", requestPayload.Email))
+	go app.auditRequest(user.PublicID, "delete_user")
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("user deleted"),
+		Data:    "",
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// DeleteByID deletes user by ID
+func (app *Config) DeleteByID(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		ID int `json:"id"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	// validate the user against the database
+	user, err := app.Models.User.GetOne(requestPayload.ID)
+	if err != nil {
+		app.errorJSON(w, errors.New("user not found"), http.StatusNotFound)
+		return
+	}
+
+	err = app.Models.User.DeleteByID(requestPayload.ID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	// log delete by id
+	go app.logRequest(user.PublicID, "delete user", fmt.Sprintf("user with id %v deleted", requestPayload.ID))
+	go app.auditRequest(user.PublicID, "delete_user")
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("deleted user"),
+		Data:    "",
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// RestoreByID restores soft deleted user by ID
+func (app *Config) RestoreByID(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		ID int `json:"id"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.Models.User.GetOneIncludingDeleted(requestPayload.ID)
+	if err != nil {
+		app.errorJSON(w, errors.New("user not found"), http.StatusNotFound)
+		return
+	}
+
+	err = app.Models.User.Restore(requestPayload.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNotDeleted):
+			app.errorJSON(w, err, http.StatusNotFound)
+		case errors.Is(err, data.ErrDuplicateEmail):
+			app.errorJSON(w, err, http.StatusConflict)
+		default:
+			app.errorJSON(w, err)
+		}
+		return
+	}
+
+	// log restore by id
+	go app.logRequest(user.PublicID, "restore user", fmt.Sprintf("user with id %v restored", requestPayload.ID))
+	go app.auditRequest(user.PublicID, "restore_user")
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "user restored",
+		Data:    requestPayload.ID,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// EraseByID permanently deletes user by ID and erases its data from logger and analysis services
+func (app *Config) EraseByID(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		ID int `json:"id"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.Models.User.GetOneIncludingDeleted(requestPayload.ID)
+	if err != nil {
+		app.errorJSON(w, errors.New("user not found"), http.StatusNotFound)
+		return
+	}
+
+	// erase data of other services first, so erasure can be retried while user exists
+	for _, url := range []string{"http://logger-service/erase", "http://analysis-service/erase"} {
+		err = app.eraseRequest(url, user.PublicID, user.Email)
+		if err != nil {
+			app.errorJSON(w, err, http.StatusBadGateway)
+			return
+		}
+	}
+
+	err = app.Models.User.Erase(requestPayload.ID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.Models.LoginAttempt.Unlock(user.Email)
+	if err != nil {
+		log.Println("error clearing login attempts of erased user:", err)
+	}
+
+	// log erase by id without any personal data, public ID of erased user isn`t written again
+	go app.logRequest("", "erase user", fmt.Sprintf("user with id %v erased", requestPayload.ID))
+	go app.auditRequest("", "erase_user")
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "user erased",
+		Data:    requestPayload.ID,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// AuthenticateSession checks valid or not session of user
+func (app *Config) AuthenticateSession(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		SessionToken string `json:"session_token"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	claims, err := app.Models.UserJWT.CheckJWTToken(requestPayload.SessionToken)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	// log session
+	go app.logRequest(claims.UserID, "checked users session", fmt.Sprintf("%s`s session is valid", claims.Email))
+
+	// analysis action
+	go app.analysisRequest(claims.UserID)
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("session is valid"),
+		Data:    claims.Email,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// VerifyEmail activates user by verification token from email
+func (app *Config) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Token string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	// consume single-use token
+	userID, err := app.Models.Token.ConsumeVerificationToken(requestPayload.Token)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.Models.User.GetOne(userID)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid credentials"), http.StatusBadRequest)
+		return
+	}
+
+	err = user.Activate()
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	// log verify email
+	go app.logRequest(user.PublicID, "verify email", fmt.Sprintf("%s verified email", user.Email))
+
+	// analysis action
+	go app.analysisRequest(user.PublicID)
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("verified email of user with id %v", user.ID),
+		Data:    user.Email,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// publicID returns public ID of user, so log entries about user are erased with user. It is empty
+// if user doesn`t exist
+func (app *Config) publicID(id int) string {
+	user, err := app.Models.User.GetOneIncludingDeleted(id)
+	if err != nil {
+		return ""
+	}
+
+	return user.PublicID
+}
+
+// publicIDByEmail returns public ID of user with email, it is empty if there is no such user
+func (app *Config) publicIDByEmail(email string) string {
+	user, err := app.Models.User.GetByEmail(email)
+	if err != nil {
+		return ""
+	}
+
+	return user.PublicID
+}
+
+// logRequest requests of logger-service to log event
+func (app *Config) logRequest(userID, name, data string) {
+	var entry struct {
+		UserID  string `json:"user_id,omitempty"`
+		Name    string `json:"name"`
+		Data    string `json:"data"`
+		Level   string `json:"level"`
+		Service string `json:"service"`
+	}
+
+	entry.UserID = userID
+	entry.Name = name
+	entry.Data = data
+	entry.Level = "info"
+	entry.Service = "authentication-service"
 
 	jsonData, _ := json.MarshalIndent(entry, "", "\t")
 	logServiceURL := "http://logger-service/log"
@@ -429,13 +1259,78 @@ func (app *Config) logRequest(name, data string) {
 	}
 }
 
+// audit records are retried auditTries times, pause between tries starts from auditBackoff and doubles
+const (
+	auditTries   = 5
+	auditBackoff = 500 * time.Millisecond
+)
+
+// auditRequest appends security event to audit log of logger-service. Record has only public ID
+// of user and action, so audit log keeps no personal data. Request is retried with backoff while
+// logger-service is unavailable, record is dropped only when all tries failed
+func (app *Config) auditRequest(userID, action string) {
+	var record struct {
+		Action  string `json:"action"`
+		UserID  string `json:"user_id,omitempty"`
+		Service string `json:"service"`
+	}
+
+	record.Action = action
+	record.UserID = userID
+	record.Service = "authentication-service"
+
+	jsonData, _ := json.MarshalIndent(record, "", "\t")
+
+	backoff := auditBackoff
+	for try := 1; ; try++ {
+		retry, err := sendAudit(jsonData)
+		if err == nil {
+			return
+		}
+
+		if !retry || try == auditTries {
+			log.Println("error appending audit record", action, err)
+			return
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// sendAudit posts audit record once and reports whether failed request may be retried
+func sendAudit(jsonData []byte) (bool, error) {
+	auditServiceURL := "http://logger-service/audit"
+
+	request, err := http.NewRequest("POST", auditServiceURL, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	response, err := client.Do(request)
+	if err != nil {
+		return true, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusCreated {
+		// logger-service is not ready or failed, bad record is never accepted
+		return response.StatusCode >= http.StatusInternalServerError,
+			fmt.Errorf("logger-service returned status %d", response.StatusCode)
+	}
+
+	return false, nil
+}
+
 // analysisRequest requests of analysis-service to analysis event
-func (app *Config) analysisRequest(email string) {
+func (app *Config) analysisRequest(userID string) {
 	var entry struct {
-		Email string `json:"email"`
+		UserID string `json:"user_id"`
 	}
 
-	entry.Email = email
+	entry.UserID = userID
 
 	jsonData, _ := json.MarshalIndent(entry, "", "\t")
 	logServiceURL := "http://analysis-service/analysis"
@@ -451,3 +1346,70 @@ func (app *Config) analysisRequest(email string) {
 		log.Println(err)
 	}
 }
+
+// eraseRequest requests service to erase all data of user
+func (app *Config) eraseRequest(url, userID, email string) error {
+	var entry struct {
+		UserID string `json:"user_id"`
+		Email  string `json:"email"`
+	}
+
+	entry.UserID = userID
+	entry.Email = email
+
+	jsonData, _ := json.MarshalIndent(entry, "", "\t")
+
+	request, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("error calling %s: status %d", url, response.StatusCode)
+	}
+
+	return nil
+}
+
+// mailRequest requests of mail-service to send message rendered from template
+func (app *Config) mailRequest(to, subject, template string, data map[string]any) {
+	var entry struct {
+		To       string         `json:"to"`
+		Subject  string         `json:"subject"`
+		Template string         `json:"template"`
+		Data     map[string]any `json:"data"`
+	}
+
+	entry.To = to
+	entry.Subject = subject
+	entry.Template = template
+	entry.Data = data
+
+	jsonData, _ := json.MarshalIndent(entry, "", "\t")
+	mailServiceURL := "http://mailer-service/send"
+
+	request, err := http.NewRequest("POST", mailServiceURL, bytes.NewBuffer(jsonData))
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	client := &http.Client{}
+	response, err := client.Do(request)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusAccepted {
+		log.Printf("error sending %s mail to %s", template, to)
+	}
+}
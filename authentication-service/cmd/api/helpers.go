@@ -1,6 +1,7 @@
 package main
 
 import (
+	"authentication/data"
 	"encoding/json"
 	"errors"
 	"io"
@@ -71,3 +72,18 @@ func (app *Config) errorJSON(w http.ResponseWriter, err error, status ...int) er
 
 	return app.writeJSON(w, statusCode, payload)
 }
+
+// validationErrorJSON sends json error response with all problems of invalid value
+func (app *Config) validationErrorJSON(w http.ResponseWriter, err error) error {
+	var validationErr *data.ValidationError
+	if !errors.As(err, &validationErr) {
+		return app.errorJSON(w, err)
+	}
+
+	var payload jsonResponse
+	payload.Error = true
+	payload.Message = err.Error()
+	payload.Data = validationErr
+
+	return app.writeJSON(w, http.StatusUnprocessableEntity, payload)
+}
@@ -2,25 +2,38 @@ package main
 
 import (
 	"authentication/data"
+	"authentication/migrations"
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"os"
+	"strconv"
 	"time"
 
+	"github.com/redis/go-redis/v9"
+
 	_ "github.com/jackc/pgconn"
 	_ "github.com/jackc/pgx/v4"
 	_ "github.com/jackc/pgx/v4/stdlib"
 )
 
-const webPort = "80"
+const (
+	webPort  = "80"
+	redisURL = "redis:6379"
+)
 
 var counts int64
 
 type Config struct {
-	DB     *sql.DB
-	Models data.Models
+	DB          *sql.DB
+	Redis       *redis.Client
+	Models      data.Models
+	FrontEndURL string
+	Providers   map[string]*identityProvider
 }
 
 func main() {
@@ -32,10 +45,52 @@ func main() {
 		log.Panic("Can't connect to Postgres!")
 	}
 
+	// authApp migrate up|down|version [steps]
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		err := migrate(conn, os.Args[2:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if os.Getenv("AUTO_MIGRATE") != "false" {
+		err := migrations.Up(conn, 0)
+		if err != nil {
+			log.Panic(err)
+		}
+	}
+
+	// authApp backfill
+	if len(os.Args) > 1 && os.Args[1] == "backfill" {
+		err := backfill(data.New(conn, nil))
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// connect to redis
+	redisClient := connectToRedis()
+	if redisClient == nil {
+		log.Panic("Can't connect to Redis!")
+	}
+
+	models := data.New(conn, redisClient)
+	configurePasswordPolicy(&models.PasswordPolicy)
+
+	err := configurePasswordHasher()
+	if err != nil {
+		log.Panic(err)
+	}
+
 	// set up config
 	app := Config{
-		DB:     conn,
-		Models: data.New(conn),
+		DB:          conn,
+		Redis:       redisClient,
+		Models:      models,
+		FrontEndURL: os.Getenv("FRONT_END_URL"),
+		Providers:   connectToProviders(),
 	}
 
 	srv := &http.Server{
@@ -43,7 +98,7 @@ func main() {
 		Handler: app.routes(),
 	}
 
-	err := srv.ListenAndServe()
+	err = srv.ListenAndServe()
 	if err != nil {
 		log.Panic(err)
 	}
@@ -86,3 +141,151 @@ func connectToDB() *sql.DB {
 		continue
 	}
 }
+
+func connectToRedis() *redis.Client {
+	var redisCounts int64
+
+	for {
+		redisClient := redis.NewClient(&redis.Options{
+			Addr:     redisURL,
+			Password: os.Getenv("REDIS_PASSWORD"),
+			DB:       0,
+		})
+
+		err := redisClient.Ping(context.Background()).Err()
+		if err != nil {
+			log.Println("Redis not yet ready ...")
+			redisCounts++
+		} else {
+			log.Println("Connected to Redis!")
+			return redisClient
+		}
+
+		if redisCounts > 10 {
+			log.Println(err)
+			return nil
+		}
+
+		log.Println("Backing off for two seconds....")
+		time.Sleep(2 * time.Second)
+		continue
+	}
+}
+
+// configurePasswordPolicy overrides default password policy with environment variables
+func configurePasswordPolicy(policy *data.PasswordPolicy) {
+	intVars := map[string]*int{
+		"PASSWORD_MIN_LENGTH": &policy.MinLength,
+		"PASSWORD_MAX_LENGTH": &policy.MaxLength,
+	}
+
+	for name, value := range intVars {
+		if env := os.Getenv(name); env != "" {
+			v, err := strconv.Atoi(env)
+			if err != nil {
+				log.Printf("invalid %s: %v", name, err)
+				continue
+			}
+			*value = v
+		}
+	}
+
+	boolVars := map[string]*bool{
+		"PASSWORD_REQUIRE_UPPER":  &policy.RequireUpper,
+		"PASSWORD_REQUIRE_LOWER":  &policy.RequireLower,
+		"PASSWORD_REQUIRE_DIGIT":  &policy.RequireDigit,
+		"PASSWORD_REQUIRE_SYMBOL": &policy.RequireSymbol,
+	}
+
+	for name, value := range boolVars {
+		if env := os.Getenv(name); env != "" {
+			v, err := strconv.ParseBool(env)
+			if err != nil {
+				log.Printf("invalid %s: %v", name, err)
+				continue
+			}
+			*value = v
+		}
+	}
+
+	if path := os.Getenv("BREACHED_PASSWORDS_FILE"); path != "" {
+		err := policy.LoadBreached(path)
+		if err != nil {
+			log.Println("error loading breached passwords:", err)
+		} else {
+			log.Println("Loaded breached passwords from", path)
+		}
+	}
+}
+
+// configurePasswordHasher sets algorithm and cost of new password hashes from environment variables.
+// Hashes of users are upgraded to them on the next login
+func configurePasswordHasher() error {
+	hasher := data.DefaultPasswordHasher()
+
+	if algorithm := os.Getenv("PASSWORD_HASH_ALGORITHM"); algorithm != "" {
+		hasher.Algorithm = algorithm
+	}
+
+	intVars := map[string]func(int){
+		"BCRYPT_COST":        func(v int) { hasher.BcryptCost = v },
+		"ARGON2_MEMORY":      func(v int) { hasher.Argon2.Memory = uint32(v) },
+		"ARGON2_ITERATIONS":  func(v int) { hasher.Argon2.Iterations = uint32(v) },
+		"ARGON2_PARALLELISM": func(v int) { hasher.Argon2.Parallelism = uint8(v) },
+	}
+
+	for name, set := range intVars {
+		if env := os.Getenv(name); env != "" {
+			v, err := strconv.Atoi(env)
+			if err != nil || v < 0 || v > math.MaxUint32 || (name == "ARGON2_PARALLELISM" && v > math.MaxUint8) {
+				return fmt.Errorf("invalid %s %q", name, env)
+			}
+			set(v)
+		}
+	}
+
+	err := data.SetPasswordHasher(hasher)
+	if err != nil {
+		return err
+	}
+
+	log.Println("Hashing passwords with", hasher.Algorithm)
+
+	return nil
+}
+
+// migrate runs migrate subcommand
+func migrate(conn *sql.DB, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: migrate up|down|version [steps]")
+	}
+
+	steps := 0
+	if len(args) > 1 {
+		var err error
+		steps, err = strconv.Atoi(args[1])
+		if err != nil || steps < 0 {
+			return fmt.Errorf("invalid steps %q", args[1])
+		}
+	}
+
+	switch args[0] {
+	case "up":
+		return migrations.Up(conn, steps)
+	case "down":
+		// roll back only the latest migration unless steps are given
+		if steps == 0 {
+			steps = 1
+		}
+		return migrations.Down(conn, steps)
+	case "version":
+		version, err := migrations.Version(conn)
+		if err != nil {
+			return err
+		}
+		log.Println("Database schema version", version)
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command %q", args[0])
+	}
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"authentication/data"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+const (
+	oidcTimeout = 10 * time.Second
+
+	// discovery of provider which is down is retried with backoff from min to max
+	oidcMinBackoff = 2 * time.Second
+	oidcMaxBackoff = 5 * time.Minute
+)
+
+// ErrProviderUnavailable is returned when discovery of identity provider failed and is not yet retried
+var ErrProviderUnavailable = errors.New("identity provider is unavailable, try again later")
+
+// ErrUserDisabled is returned when user with verified email was deactivated by admin
+var ErrUserDisabled = errors.New("user is disabled")
+
+// identityProvider stores client of external OpenID Connect provider. Provider is discovered
+// lazily, so provider which is down at startup is discovered once it is up
+type identityProvider struct {
+	name         string
+	issuerURL    string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+
+	mu       sync.Mutex
+	verifier *oidc.IDTokenVerifier
+	oauth2   oauth2.Config
+	backoff  time.Duration
+	retryAt  time.Time
+}
+
+// oidcClaims stores claims of ID token which are needed to find or create user
+type oidcClaims struct {
+	Subject       string `json:"sub"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	GivenName     string `json:"given_name"`
+	FamilyName    string `json:"family_name"`
+	Nonce         string `json:"nonce"`
+}
+
+// connectToProviders discovers providers listed in OIDC_PROVIDERS, e.g. "sso,google".
+// Every provider is configured with OIDC_<NAME>_ISSUER_URL, OIDC_<NAME>_CLIENT_ID,
+// OIDC_<NAME>_CLIENT_SECRET and OIDC_<NAME>_REDIRECT_URL. Provider which can`t be
+// discovered is kept and discovered again when user logs in with it
+func connectToProviders() map[string]*identityProvider {
+	providers := make(map[string]*identityProvider)
+
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+
+		provider := &identityProvider{
+			name:         name,
+			issuerURL:    os.Getenv(prefix + "ISSUER_URL"),
+			clientID:     os.Getenv(prefix + "CLIENT_ID"),
+			clientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			redirectURL:  os.Getenv(prefix + "REDIRECT_URL"),
+		}
+		providers[name] = provider
+
+		err := provider.discover()
+		if err != nil {
+			log.Printf("error connecting to identity provider %s, retrying on login: %v", name, err)
+			continue
+		}
+
+		log.Println("Connected to identity provider", name)
+	}
+
+	return providers
+}
+
+// discover fetches discovery document and keys of provider if it is not yet discovered.
+// Failed discovery is not retried until backoff passes, so provider which is down isn`t
+// requested on every login
+func (p *identityProvider) discover() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.verifier != nil {
+		return nil
+	}
+
+	if time.Now().Before(p.retryAt) {
+		return ErrProviderUnavailable
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), oidcTimeout)
+	defer cancel()
+
+	provider, err := oidc.NewProvider(ctx, p.issuerURL)
+	if err != nil {
+		p.backoff = min(max(2*p.backoff, oidcMinBackoff), oidcMaxBackoff)
+		p.retryAt = time.Now().Add(p.backoff)
+
+		return err
+	}
+
+	p.verifier = provider.Verifier(&oidc.Config{ClientID: p.clientID})
+	p.oauth2 = oauth2.Config{
+		ClientID:     p.clientID,
+		ClientSecret: p.clientSecret,
+		Endpoint:     provider.Endpoint(),
+		RedirectURL:  p.redirectURL,
+		Scopes:       []string{oidc.ScopeOpenID, "email", "profile"},
+	}
+	p.backoff = 0
+
+	return nil
+}
+
+// StartOIDCLogin returns authorization URL of provider with state, nonce and PKCE challenge
+func (app *Config) StartOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Provider string `json:"provider"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	provider, ok := app.Providers[requestPayload.Provider]
+	if !ok {
+		app.errorJSON(w, fmt.Errorf("unknown identity provider %q", requestPayload.Provider), http.StatusBadRequest)
+		return
+	}
+
+	err = provider.discover()
+	if err != nil {
+		log.Printf("error connecting to identity provider %s: %v", provider.name, err)
+		app.errorJSON(w, ErrProviderUnavailable, http.StatusServiceUnavailable)
+		return
+	}
+
+	nonce, err := app.Models.UserIdentity.RandomValue()
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	verifier := oauth2.GenerateVerifier()
+
+	state, err := app.Models.UserIdentity.CreateState(data.OIDCState{
+		Provider:     provider.name,
+		CodeVerifier: verifier,
+		Nonce:        nonce,
+	})
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	authURL := provider.oauth2.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("redirect user to %s", provider.name),
+		Data: struct {
+			AuthorizationURL string `json:"authorization_url"`
+			State            string `json:"state"`
+		}{
+			AuthorizationURL: authURL,
+			State:            state,
+		},
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// FinishOIDCLogin exchanges authorization code, links provider`s account to user and logs user in
+func (app *Config) FinishOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		State string `json:"state"`
+		Code  string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	state, err := app.Models.UserIdentity.ConsumeState(requestPayload.State)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	provider, ok := app.Providers[state.Provider]
+	if !ok {
+		app.errorJSON(w, fmt.Errorf("unknown identity provider %q", state.Provider), http.StatusBadRequest)
+		return
+	}
+
+	err = provider.discover()
+	if err != nil {
+		log.Printf("error connecting to identity provider %s: %v", provider.name, err)
+		app.errorJSON(w, ErrProviderUnavailable, http.StatusServiceUnavailable)
+		return
+	}
+
+	claims, err := provider.exchange(requestPayload.Code, state)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	user, err := app.findOrCreateOIDCUser(provider.name, claims)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	// log external login
+	go app.logRequest(user.PublicID, "oidc login", fmt.Sprintf("%s logged in with %s", user.Email, provider.name))
+	go app.auditRequest(user.PublicID, "oidc_login")
+
+	app.completeLogin(w, user)
+}
+
+// exchange exchanges authorization code for tokens and verifies ID token
+func (p *identityProvider) exchange(code string, state *data.OIDCState) (*oidcClaims, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), oidcTimeout)
+	defer cancel()
+
+	token, err := p.oauth2.Exchange(ctx, code, oauth2.VerifierOption(state.CodeVerifier))
+	if err != nil {
+		return nil, fmt.Errorf("error exchanging code: %v", err)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("no id_token in token response")
+	}
+
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id_token: %v", err)
+	}
+
+	var claims oidcClaims
+
+	err = idToken.Claims(&claims)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Nonce != state.Nonce {
+		return nil, errors.New("invalid nonce")
+	}
+
+	return &claims, nil
+}
+
+// findOrCreateOIDCUser returns user linked to provider`s account. Account is linked to existing user
+// with the same email only if provider verified the email, otherwise error is returned. Unverified
+// user is activated by provider`s verification, but user disabled by admin can`t log in
+func (app *Config) findOrCreateOIDCUser(provider string, claims *oidcClaims) (*data.User, error) {
+	userID, err := app.Models.UserIdentity.GetUserID(provider, claims.Subject)
+	if err == nil {
+		user, err := app.Models.User.GetOneWithPassword(userID)
+		if err != nil {
+			return nil, err
+		}
+
+		if user.Active == 0 {
+			return nil, ErrUserDisabled
+		}
+
+		return user, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, errors.New("identity provider did not verify email")
+	}
+
+	user, err := app.Models.User.GetByEmailWithPassword(claims.Email)
+	switch {
+	case err == nil:
+		// existing password account
+		if user.Active == 0 && user.EmailVerified {
+			return nil, ErrUserDisabled
+		}
+	case errors.Is(err, sql.ErrNoRows):
+		user, err = app.createOIDCUser(claims)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	err = app.Models.UserIdentity.Link(user.ID, provider, claims.Subject, claims.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	// email is verified by provider
+	if !user.EmailVerified {
+		err = user.Activate()
+		if err != nil {
+			return nil, err
+		}
+		user.Active = 1
+		user.EmailVerified = true
+	}
+
+	return user, nil
+}
+
+// createOIDCUser creates user without usable password, so user can log in only with provider
+// or after password reset
+func (app *Config) createOIDCUser(claims *oidcClaims) (*data.User, error) {
+	password, err := app.Models.UserIdentity.RandomValue()
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := app.Models.User.Insert(data.User{
+		Email:         claims.Email,
+		FirstName:     claims.GivenName,
+		LastName:      claims.FamilyName,
+		Password:      password,
+		Active:        1,
+		EmailVerified: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := app.Models.User.GetOneWithPassword(id)
+	if err != nil {
+		return nil, err
+	}
+
+	// log registration
+	go app.logRequest(user.PublicID, "registered", fmt.Sprintf("%s registered in with identity provider", user.Email))
+
+	return user, nil
+}
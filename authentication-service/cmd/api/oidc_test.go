@@ -0,0 +1,204 @@
+package main
+
+import (
+	"authentication/data"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+const (
+	mockClientID = "mock-client"
+	mockCode     = "mock-code"
+	mockNonce    = "mock-nonce"
+	mockKeyID    = "mock-key"
+)
+
+// mockOIDC is local OpenID Connect provider which issues ID token for one authorization code
+type mockOIDC struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu        sync.Mutex
+	down      bool
+	challenge string
+}
+
+func newMockOIDC(t *testing.T) *mockOIDC {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &mockOIDC{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", m.discovery)
+	mux.HandleFunc("/keys", m.keys)
+	mux.HandleFunc("/token", m.token)
+
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+
+	return m
+}
+
+func (m *mockOIDC) setDown(down bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.down = down
+}
+
+func (m *mockOIDC) discovery(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	down := m.down
+	m.mu.Unlock()
+
+	if down {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]any{
+		"issuer":                                m.URL,
+		"authorization_endpoint":                m.URL + "/authorize",
+		"token_endpoint":                        m.URL + "/token",
+		"jwks_uri":                              m.URL + "/keys",
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+func (m *mockOIDC) keys(w http.ResponseWriter, r *http.Request) {
+	encode := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+	json.NewEncoder(w).Encode(map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"kid": mockKeyID,
+			"n":   encode(m.key.N.Bytes()),
+			"e":   encode(big.NewInt(int64(m.key.E)).Bytes()),
+		}},
+	})
+}
+
+// token checks authorization code and PKCE verifier and returns signed ID token
+func (m *mockOIDC) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+
+	m.mu.Lock()
+	challenge := m.challenge
+	m.mu.Unlock()
+
+	if r.PostForm.Get("code") != mockCode || base64.RawURLEncoding.EncodeToString(sum[:]) != challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            m.URL,
+		"aud":            mockClientID,
+		"sub":            "mock-subject",
+		"email":          "bob@example.com",
+		"email_verified": true,
+		"nonce":          mockNonce,
+		"iat":            time.Now().Unix(),
+		"exp":            time.Now().Add(time.Minute).Unix(),
+	})
+	token.Header["kid"] = mockKeyID
+
+	idToken, err := token.SignedString(m.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token": "mock-access-token",
+		"token_type":   "Bearer",
+		"expires_in":   60,
+		"id_token":     idToken,
+	})
+}
+
+func newTestProvider(issuerURL string) *identityProvider {
+	return &identityProvider{
+		name:        "mock",
+		issuerURL:   issuerURL,
+		clientID:    mockClientID,
+		redirectURL: "http://localhost/oidc_callback",
+	}
+}
+
+func TestDiscoverRetriesProviderWhichWasDown(t *testing.T) {
+	m := newMockOIDC(t)
+	m.setDown(true)
+
+	provider := newTestProvider(m.URL)
+
+	if err := provider.discover(); err == nil {
+		t.Fatal("expected discovery of provider which is down to fail")
+	}
+
+	m.setDown(false)
+
+	// provider is not requested again until backoff passes
+	if err := provider.discover(); !errors.Is(err, ErrProviderUnavailable) {
+		t.Fatalf("expected %v during backoff, got %v", ErrProviderUnavailable, err)
+	}
+
+	provider.retryAt = time.Time{}
+
+	if err := provider.discover(); err != nil {
+		t.Fatalf("expected discovery to succeed once provider is up, got %v", err)
+	}
+}
+
+func TestExchangeVerifiesIDTokenWithPKCE(t *testing.T) {
+	m := newMockOIDC(t)
+	provider := newTestProvider(m.URL)
+
+	if err := provider.discover(); err != nil {
+		t.Fatal(err)
+	}
+
+	verifier := oauth2.GenerateVerifier()
+	m.challenge = oauth2.S256ChallengeFromVerifier(verifier)
+
+	claims, err := provider.exchange(mockCode, &data.OIDCState{CodeVerifier: verifier, Nonce: mockNonce})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if claims.Subject != "mock-subject" || claims.Email != "bob@example.com" || !claims.EmailVerified {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+
+	if _, err := provider.exchange(mockCode, &data.OIDCState{CodeVerifier: "wrong", Nonce: mockNonce}); err == nil {
+		t.Fatal("expected exchange with wrong code verifier to fail")
+	}
+
+	if _, err := provider.exchange(mockCode, &data.OIDCState{CodeVerifier: verifier, Nonce: "other"}); err == nil {
+		t.Fatal("expected exchange with wrong nonce to fail")
+	}
+}
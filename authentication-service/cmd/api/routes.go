@@ -14,8 +14,8 @@ func (app *Config) routes() http.Handler {
 	// specify who is allowed to connect
 	mux.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"https://*", "http://*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "If-Match"},
 		ExposedHeaders:   []string{"Link"},
 		AllowCredentials: true,
 		MaxAge:           300,
@@ -23,16 +23,36 @@ func (app *Config) routes() http.Handler {
 
 	mux.Use(middleware.Heartbeat("/ping"))
 
-	mux.Get("/get_all", app.GetAll)
+	mux.Post("/get_all", app.GetAll)
 	mux.Put("/update", app.Update)
+	mux.Patch("/update", app.Update)
 	mux.Put("/change_password", app.ChangePassword)
+	mux.Post("/request_password_reset", app.RequestPasswordReset)
+	mux.Put("/confirm_password_reset", app.ConfirmPasswordReset)
 	mux.Post("/authenticate", app.Authenticate)
+	mux.Post("/authenticate_2fa", app.Authenticate2FA)
+	mux.Post("/oidc_start", app.StartOIDCLogin)
+	mux.Post("/oidc_callback", app.FinishOIDCLogin)
 	mux.Post("/authenticate_session", app.AuthenticateSession)
+	mux.Post("/enroll_2fa", app.EnrollTwoFactor)
+	mux.Post("/confirm_2fa", app.ConfirmTwoFactor)
+	mux.Post("/create_api_key", app.CreateAPIKey)
+	mux.Post("/get_api_keys", app.GetAPIKeys)
+	mux.Post("/revoke_api_key", app.RevokeAPIKey)
+	mux.Post("/verify_credentials", app.VerifyCredentials)
+	mux.Post("/create_stream_token", app.CreateStreamToken)
+	mux.Post("/unlock_user", app.UnlockUser)
 	mux.Post("/registration", app.Registration)
+	mux.Post("/import_users", app.ImportUsers)
+	mux.Get("/export_users", app.ExportUsers)
+	mux.Post("/verify_email", app.VerifyEmail)
+	mux.Post("/confirm_email_change", app.ConfirmEmailChange)
 	mux.Post("/get_by_email", app.GetByEmail)
 	mux.Post("/get_by_id", app.GetByID)
 	mux.Delete("/delete_by_email", app.DeleteByEmail)
 	mux.Delete("/delete_by_id", app.DeleteByID)
+	mux.Put("/restore_by_id", app.RestoreByID)
+	mux.Delete("/erase_by_id", app.EraseByID)
 
 	return mux
 }
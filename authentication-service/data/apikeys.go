@@ -0,0 +1,273 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// apiKeyPrefix marks plain text API keys, so they are easy to find in leaked configs
+const apiKeyPrefix = "gm_"
+
+// Scopes of API keys, broker checks them for every action
+const (
+	ScopeUsersRead  = "users:read"
+	ScopeUsersWrite = "users:write"
+	ScopeUsersAdmin = "users:admin"
+	ScopeLogsRead   = "logs:read"
+	ScopeLogsWrite  = "logs:write"
+	ScopeMailSend   = "mail:send"
+)
+
+// APIKeyScopes are all scopes which API key can be granted
+var APIKeyScopes = []string{ScopeUsersRead, ScopeUsersWrite, ScopeUsersAdmin, ScopeLogsRead, ScopeLogsWrite,
+	ScopeMailSend}
+
+// selfScopes are scopes of users who are not admins, broker limits users actions to user`s own account
+var selfScopes = []string{ScopeUsersRead, ScopeUsersWrite, ScopeLogsWrite, ScopeMailSend}
+
+// UserScopes returns scopes which user has and can grant to API keys. Only admins have all scopes
+func UserScopes(isAdmin bool) []string {
+	if isAdmin {
+		return APIKeyScopes
+	}
+
+	return selfScopes
+}
+
+var ErrInvalidAPIKey = errors.New("invalid or revoked api key")
+
+// APIKey stores hash of API key of machine client, plain text key is returned only once on create
+type APIKey struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"-"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Key        string     `json:"key,omitempty"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// APIKeyOwner stores user of API key with granted scopes
+type APIKeyOwner struct {
+	KeyID   int      `json:"key_id"`
+	ID      int      `json:"id"`
+	UserID  string   `json:"user_id"`
+	Email   string   `json:"email"`
+	IsAdmin bool     `json:"is_admin"`
+	Scopes  []string `json:"scopes"`
+}
+
+// ValidateScopes checks that scopes are known and not repeated
+func ValidateScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return errors.New("api key must have at least one scope")
+	}
+
+	seen := make(map[string]bool, len(scopes))
+
+	for _, scope := range scopes {
+		if !HasScope(APIKeyScopes, scope) {
+			return fmt.Errorf("unknown scope %q, allowed scopes: %s", scope, strings.Join(APIKeyScopes, ", "))
+		}
+		if seen[scope] {
+			return fmt.Errorf("scope %q is repeated", scope)
+		}
+		seen[scope] = true
+	}
+
+	return nil
+}
+
+// HasScope reports whether scope is one of scopes
+func HasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// New creates API key of user with scopes, stores its hash and returns key with plain text value
+func (k *APIKey) New(userID int, name string, scopes []string) (*APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	first, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	second, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	plainText := apiKeyPrefix + strings.ToLower(first+second)
+	hash := sha256.Sum256([]byte(plainText))
+
+	apiKey := APIKey{
+		UserID:    userID,
+		Name:      name,
+		Prefix:    plainText[:len(apiKeyPrefix)+8],
+		Key:       plainText,
+		Scopes:    scopes,
+		CreatedAt: time.Now(),
+	}
+
+	stmt := `insert into api_keys (user_id, name, prefix, key_hash, scopes, created_at)
+		values ($1, $2, $3, $4, $5, $6) returning id`
+
+	err = db.QueryRowContext(ctx, stmt,
+		apiKey.UserID,
+		apiKey.Name,
+		apiKey.Prefix,
+		hash[:],
+		strings.Join(apiKey.Scopes, " "),
+		apiKey.CreatedAt,
+	).Scan(&apiKey.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &apiKey, nil
+}
+
+// GetAllByUser returns all API keys of user, revoked keys are included
+func (k *APIKey) GetAllByUser(userID int) ([]*APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, user_id, name, prefix, scopes, created_at, last_used_at, revoked_at
+		from api_keys where user_id = $1 order by created_at desc, id desc`
+
+	rows, err := db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	apiKeys := []*APIKey{}
+
+	for rows.Next() {
+		var apiKey APIKey
+		var scopes string
+		var lastUsedAt, revokedAt sql.NullTime
+
+		err := rows.Scan(
+			&apiKey.ID,
+			&apiKey.UserID,
+			&apiKey.Name,
+			&apiKey.Prefix,
+			&scopes,
+			&apiKey.CreatedAt,
+			&lastUsedAt,
+			&revokedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		apiKey.Scopes = strings.Fields(scopes)
+		if lastUsedAt.Valid {
+			apiKey.LastUsedAt = &lastUsedAt.Time
+		}
+		if revokedAt.Valid {
+			apiKey.RevokedAt = &revokedAt.Time
+		}
+
+		apiKeys = append(apiKeys, &apiKey)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return apiKeys, nil
+}
+
+// Revoke revokes API key of user, revoked key can`t be used anymore
+func (k *APIKey) Revoke(id, userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update api_keys set revoked_at = $1 where id = $2 and user_id = $3 and revoked_at is null`
+
+	result, err := db.ExecContext(ctx, stmt, time.Now(), id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return errors.New("api key not found or already revoked")
+	}
+
+	return nil
+}
+
+// GetOwner returns owner and scopes of not revoked API key of not deleted active user
+func (k *APIKey) GetOwner(plainText string) (*APIKeyOwner, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	if !strings.HasPrefix(plainText, apiKeyPrefix) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	hash := sha256.Sum256([]byte(plainText))
+
+	query := `select k.id, u.id, u.public_id, u.email, u.is_admin, k.scopes from api_keys k
+		join users u on u.id = k.user_id
+		where k.key_hash = $1 and k.revoked_at is null and u.deleted_at is null and u.user_active = 1`
+
+	var owner APIKeyOwner
+	var scopes string
+
+	err := db.QueryRowContext(ctx, query, hash[:]).Scan(&owner.KeyID, &owner.ID, &owner.UserID, &owner.Email,
+		&owner.IsAdmin, &scopes)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidAPIKey
+		}
+		return nil, err
+	}
+
+	// key of admin who is not admin anymore loses scopes of admins
+	for _, scope := range strings.Fields(scopes) {
+		if HasScope(UserScopes(owner.IsAdmin), scope) {
+			owner.Scopes = append(owner.Scopes, scope)
+		}
+	}
+
+	return &owner, nil
+}
+
+// Touch updates last used time of API key. It is written at most once a minute, so busy
+// clients don`t update the row on every request
+func (k *APIKey) Touch(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update api_keys set last_used_at = $1
+		where id = $2 and (last_used_at is null or last_used_at < $3)`
+
+	_, err := db.ExecContext(ctx, stmt, time.Now(), id, time.Now().Add(-time.Minute))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,209 @@
+package data
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+const (
+	maxAccountAttempts = 5
+	maxIPAttempts      = 20
+	attemptsWindow     = 15 * time.Minute
+	lockoutDuration    = 15 * time.Minute
+	baseDelay          = time.Second
+	maxDelay           = 30 * time.Second
+)
+
+// LoginAttempt counts failed logins per account and per IP in redis
+type LoginAttempt struct{}
+
+// Check returns how long account or IP has to wait before the next login attempt
+func (la *LoginAttempt) Check(email, ip string) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	keys := []string{lockKey("account", email), delayKey(email)}
+	if ip != "" {
+		keys = append(keys, lockKey("ip", ip))
+	}
+
+	var wait time.Duration
+
+	for _, key := range keys {
+		ttl, err := rclient.PTTL(ctx, key).Result()
+		if err != nil {
+			return 0, err
+		}
+
+		// negative ttl means key does not exist
+		if ttl > wait {
+			wait = ttl
+		}
+	}
+
+	return wait, nil
+}
+
+// Fail registers failed login attempt and returns true if account or IP was locked out
+func (la *LoginAttempt) Fail(email, ip string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	locked := false
+
+	failures, err := incrWithWindow(ctx, failKey("account", email))
+	if err != nil {
+		return false, err
+	}
+
+	if failures >= maxAccountAttempts {
+		err = lock(ctx, "account", email)
+		if err != nil {
+			return false, err
+		}
+		locked = true
+	} else {
+		// every next failure doubles the delay before the next attempt
+		delay := baseDelay << (failures - 1)
+		if delay > maxDelay {
+			delay = maxDelay
+		}
+
+		err = rclient.Set(ctx, delayKey(email), failures, delay).Err()
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if ip == "" {
+		return locked, nil
+	}
+
+	// ips which failed login of account are remembered, so Unlock removes their lockout too
+	err = rclient.SAdd(ctx, ipsKey(email), ip).Err()
+	if err != nil {
+		return false, err
+	}
+	err = rclient.Expire(ctx, ipsKey(email), attemptsWindow+lockoutDuration).Err()
+	if err != nil {
+		return false, err
+	}
+
+	failures, err = incrWithWindow(ctx, failKey("ip", ip))
+	if err != nil {
+		return false, err
+	}
+
+	if failures >= maxIPAttempts {
+		err = lock(ctx, "ip", ip)
+		if err != nil {
+			return false, err
+		}
+		locked = true
+	}
+
+	return locked, nil
+}
+
+// Reset clears failed login attempts of account after successful login
+func (la *LoginAttempt) Reset(email string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	err := rclient.Del(ctx, failKey("account", email), delayKey(email)).Err()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Unlock removes lockout and failed login attempts of account and of IPs which failed its login
+func (la *LoginAttempt) Unlock(email string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	keys := []string{lockKey("account", email), failKey("account", email), delayKey(email), ipsKey(email)}
+
+	ips, err := rclient.SMembers(ctx, ipsKey(email)).Result()
+	if err != nil {
+		return err
+	}
+	for _, ip := range ips {
+		keys = append(keys, lockKey("ip", ip), failKey("ip", ip))
+	}
+
+	err = rclient.Del(ctx, keys...).Err()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// IsLocked checks if account or IP which failed its login is locked out
+func (la *LoginAttempt) IsLocked(email string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	keys := []string{lockKey("account", email)}
+
+	ips, err := rclient.SMembers(ctx, ipsKey(email)).Result()
+	if err != nil {
+		return false, err
+	}
+	for _, ip := range ips {
+		keys = append(keys, lockKey("ip", ip))
+	}
+
+	count, err := rclient.Exists(ctx, keys...).Result()
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// incrWithWindow increments counter which lives during attempts window since the first failure
+func incrWithWindow(ctx context.Context, key string) (int64, error) {
+	count, err := rclient.Incr(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	if count == 1 {
+		err = rclient.Expire(ctx, key, attemptsWindow).Err()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return count, nil
+}
+
+// lock locks out account or IP and resets its counter
+func lock(ctx context.Context, kind, value string) error {
+	err := rclient.Set(ctx, lockKey(kind, value), 1, lockoutDuration).Err()
+	if err != nil {
+		return err
+	}
+
+	return rclient.Del(ctx, failKey(kind, value)).Err()
+}
+
+func failKey(kind, value string) string {
+	return "login:fail:" + kind + ":" + strings.ToLower(value)
+}
+
+func lockKey(kind, value string) string {
+	return "login:lock:" + kind + ":" + strings.ToLower(value)
+}
+
+func ipsKey(email string) string {
+	return "login:ips:account:" + strings.ToLower(email)
+}
+
+func delayKey(email string) string {
+	return "login:delay:account:" + strings.ToLower(email)
+}
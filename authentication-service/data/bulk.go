@@ -0,0 +1,223 @@
+package data
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exportTimeout is longer than dbTimeout, because export reads all users
+const exportTimeout = 10 * time.Minute
+
+// MaxImportRows is the maximum number of users in one import
+const MaxImportRows = 1000
+
+// ImportRow stores one user of import file
+type ImportRow struct {
+	Row          int    `json:"-"`
+	Email        string `json:"email"`
+	FirstName    string `json:"first_name"`
+	LastName     string `json:"last_name"`
+	Password     string `json:"password"`
+	PasswordHash string `json:"password_hash"`
+	Active       *int   `json:"active"`
+}
+
+// ParseImport parses users from CSV with header row or from JSON array
+func ParseImport(format, content string) ([]ImportRow, error) {
+	var rows []ImportRow
+
+	switch format {
+	case "json":
+		err := json.Unmarshal([]byte(content), &rows)
+		if err != nil {
+			return nil, fmt.Errorf("invalid json: %v", err)
+		}
+
+		for i := range rows {
+			rows[i].Row = i + 1
+		}
+	case "csv", "":
+		var err error
+
+		rows, err = parseImportCSV(content)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown format %q, format must be csv or json", format)
+	}
+
+	if len(rows) == 0 {
+		return nil, errors.New("no users to import")
+	}
+	if len(rows) > MaxImportRows {
+		return nil, fmt.Errorf("at most %d users can be imported at once", MaxImportRows)
+	}
+
+	return rows, nil
+}
+
+// parseImportCSV parses CSV whose columns are named by header row. Row numbers count data rows from 1
+func parseImportCSV(content string) ([]ImportRow, error) {
+	reader := csv.NewReader(strings.NewReader(content))
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("invalid csv header: %v", err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	if _, ok := columns["email"]; !ok {
+		return nil, errors.New("csv must have email column")
+	}
+
+	value := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	var rows []ImportRow
+
+	for n := 1; ; n++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid csv: %v", err)
+		}
+
+		row := ImportRow{
+			Row:          n,
+			Email:        value(record, "email"),
+			FirstName:    value(record, "first_name"),
+			LastName:     value(record, "last_name"),
+			Password:     value(record, "password"),
+			PasswordHash: value(record, "password_hash"),
+		}
+
+		if active := value(record, "active"); active != "" {
+			a, err := strconv.Atoi(active)
+			if err != nil {
+				return nil, fmt.Errorf("invalid active value %q in row %d", active, n)
+			}
+			row.Active = &a
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// ValidatePasswordHash checks that hash is bcrypt or argon2id hash which can be stored as is.
+// Hashes with weaker parameters than configured are upgraded on the first login
+func ValidatePasswordHash(hash string) error {
+	err := passwordHasher.ValidHash(hash)
+	if err != nil {
+		return errors.New("password_hash must be bcrypt or argon2id hash")
+	}
+
+	return nil
+}
+
+// InsertWithHash inserts new user whose password is already hashed
+func (u *User) InsertWithHash(user User, passwordHash string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var newID int
+	stmt := `insert into users (email, first_name, last_name, password, user_active, email_verified, created_at,
+		updated_at) values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`
+
+	err := db.QueryRowContext(ctx, stmt,
+		user.Email,
+		user.FirstName,
+		user.LastName,
+		passwordHash,
+		user.Active,
+		user.EmailVerified,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return 0, ErrDuplicateEmail
+		}
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// EmailExists reports whether any user has email, deleted users are checked too, so imported user
+// doesn`t block restore of deleted one
+func (u *User) EmailExists(email string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select exists(select 1 from users where lower(email) = lower($1))`
+
+	var exists bool
+	err := db.QueryRowContext(ctx, query, email).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+
+	return exists, nil
+}
+
+// Export calls fn for every not deleted user ordered by id without loading all users in memory.
+// Passwords are never read
+func (u *User) Export(fn func(*User) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+
+	query := `select id, public_id, email, first_name, last_name, user_active, created_at, updated_at
+	from users where deleted_at is null order by id`
+
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var user User
+		err := rows.Scan(
+			&user.ID,
+			&user.PublicID,
+			&user.Email,
+			&user.FirstName,
+			&user.LastName,
+			&user.Active,
+			&user.CreatedAt,
+			&user.UpdatedAt,
+		)
+		if err != nil {
+			return err
+		}
+
+		err = fn(&user)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
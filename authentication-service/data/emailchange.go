@@ -0,0 +1,98 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+const EmailChangeTTL = 24 * time.Hour
+
+// EmailChange stores pending change of user`s email, which must be confirmed from new address
+type EmailChange struct {
+	UserID   int    `json:"user_id"`
+	PublicID string `json:"public_id"`
+	OldEmail string `json:"old_email"`
+	NewEmail string `json:"new_email"`
+}
+
+// Create replaces pending email change of user and returns plain text confirmation token
+func (ec *EmailChange) Create(userID int, newEmail string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	plainText, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.Sum256([]byte(plainText))
+
+	stmt := `insert into email_changes (user_id, new_email, token_hash, expiry, created_at)
+		values ($1, $2, $3, $4, $5)
+		on conflict (user_id) do update
+		set new_email = excluded.new_email, token_hash = excluded.token_hash,
+		expiry = excluded.expiry, created_at = excluded.created_at`
+
+	_, err = db.ExecContext(ctx, stmt, userID, newEmail, hash[:], time.Now().Add(EmailChangeTTL), time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	return plainText, nil
+}
+
+// Confirm consumes confirmation token and changes email of user. All sessions of user are revoked
+func (ec *EmailChange) Confirm(plainText string) (*EmailChange, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	hash := sha256.Sum256([]byte(plainText))
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var change EmailChange
+
+	stmt := `delete from email_changes where token_hash = $1 and expiry > $2 returning user_id, new_email`
+
+	err = tx.QueryRowContext(ctx, stmt, hash[:], time.Now()).Scan(&change.UserID, &change.NewEmail)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+
+	err = tx.QueryRowContext(ctx, `select email from users where id = $1 and deleted_at is null for update`,
+		change.UserID).Scan(&change.OldEmail)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+
+	stmt = `update users set email = $1, version = version + 1, token_version = token_version + 1, updated_at = $2
+		where id = $3 returning public_id`
+
+	err = tx.QueryRowContext(ctx, stmt, change.NewEmail, time.Now(), change.UserID).Scan(&change.PublicID)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrDuplicateEmail
+		}
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return &change, nil
+}
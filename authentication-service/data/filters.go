@@ -0,0 +1,122 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// usersFilterWhere filters users by $1 active, $2 created from, $3 created to, $4 search substring
+// and $5 deleted or not
+const usersFilterWhere = `where (deleted_at is not null) = $5
+	and ($1::integer is null or user_active = $1)
+	and ($2::timestamp is null or created_at >= $2)
+	and ($3::timestamp is null or created_at <= $3)
+	and ($4::text = '' or email ilike '%' || $4 || '%' or first_name ilike '%' || $4 || '%' or last_name ilike '%' || $4 || '%')`
+
+// sortColumns maps allowed sort values to columns of users table
+var sortColumns = map[string]string{
+	"id":         "id",
+	"email":      "email",
+	"first_name": "first_name",
+	"last_name":  "last_name",
+	"created_at": "created_at",
+	"updated_at": "updated_at",
+}
+
+// UserFilter stores pagination, filters and sort order of users list
+type UserFilter struct {
+	Page        int        `json:"page,omitempty"`
+	PageSize    int        `json:"page_size,omitempty"`
+	Active      *int       `json:"active,omitempty"`
+	CreatedFrom *time.Time `json:"created_from,omitempty"`
+	CreatedTo   *time.Time `json:"created_to,omitempty"`
+	Search      string     `json:"search,omitempty"`
+	Sort        string     `json:"sort,omitempty"`
+	Deleted     bool       `json:"deleted,omitempty"`
+}
+
+// Metadata stores pagination data of users list
+type Metadata struct {
+	CurrentPage  int `json:"current_page,omitempty"`
+	PageSize     int `json:"page_size,omitempty"`
+	FirstPage    int `json:"first_page,omitempty"`
+	LastPage     int `json:"last_page,omitempty"`
+	TotalRecords int `json:"total_records"`
+}
+
+// Validate sets default values of filter and checks it
+func (f *UserFilter) Validate() error {
+	if f.Page == 0 {
+		f.Page = 1
+	}
+	if f.PageSize == 0 {
+		f.PageSize = defaultPageSize
+	}
+	if f.Sort == "" {
+		f.Sort = "last_name"
+	}
+
+	if f.Page < 0 || f.Page > 10_000_000 {
+		return errors.New("page must be between 1 and 10000000")
+	}
+	if f.PageSize < 0 || f.PageSize > maxPageSize {
+		return fmt.Errorf("page_size must be between 1 and %d", maxPageSize)
+	}
+	if f.Active != nil && *f.Active != 0 && *f.Active != 1 {
+		return errors.New("active must be 0 or 1")
+	}
+	if f.CreatedFrom != nil && f.CreatedTo != nil && f.CreatedFrom.After(*f.CreatedTo) {
+		return errors.New("created_from must be before created_to")
+	}
+	if _, ok := sortColumns[strings.TrimPrefix(f.Sort, "-")]; !ok {
+		return fmt.Errorf("invalid sort value %q", f.Sort)
+	}
+
+	return nil
+}
+
+// orderBy returns ORDER BY clause, column is taken from safelist only
+func (f *UserFilter) orderBy() string {
+	direction := "asc"
+	if strings.HasPrefix(f.Sort, "-") {
+		direction = "desc"
+	}
+
+	// id keeps order stable between pages
+	return fmt.Sprintf("%s %s, id asc", sortColumns[strings.TrimPrefix(f.Sort, "-")], direction)
+}
+
+func (f *UserFilter) limit() int {
+	return f.PageSize
+}
+
+func (f *UserFilter) offset() int {
+	return (f.Page - 1) * f.PageSize
+}
+
+// escapeLike escapes wildcards of LIKE pattern, so search matches them literally
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(strings.TrimSpace(s))
+}
+
+// calculateMetadata returns pagination data for total records
+func calculateMetadata(totalRecords, page, pageSize int) Metadata {
+	if totalRecords == 0 {
+		return Metadata{}
+	}
+
+	return Metadata{
+		CurrentPage:  page,
+		PageSize:     pageSize,
+		FirstPage:    1,
+		LastPage:     (totalRecords + pageSize - 1) / pageSize,
+		TotalRecords: totalRecords,
+	}
+}
@@ -0,0 +1,248 @@
+package data
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	AlgorithmBcrypt   = "bcrypt"
+	AlgorithmArgon2id = "argon2id"
+)
+
+// maxArgon2Factor is how many times parameters of stored argon2id hash may exceed configured ones
+const maxArgon2Factor = 4
+
+var ErrUnknownHash = errors.New("unknown password hash format")
+
+// Argon2Params stores parameters of argon2id, memory is in KiB
+type Argon2Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// PasswordHasher hashes new passwords with configured algorithm and checks hashes of any
+// supported algorithm, so hashes can be upgraded on login
+type PasswordHasher struct {
+	Algorithm  string
+	BcryptCost int
+	Argon2     Argon2Params
+}
+
+// passwordHasher is used by all models, it is changed by SetPasswordHasher on start
+var passwordHasher = DefaultPasswordHasher()
+
+// DefaultPasswordHasher returns bcrypt hasher with cost 12 and argon2id parameters recommended by RFC 9106
+func DefaultPasswordHasher() PasswordHasher {
+	return PasswordHasher{
+		Algorithm:  AlgorithmBcrypt,
+		BcryptCost: 12,
+		Argon2: Argon2Params{
+			Memory:      64 * 1024,
+			Iterations:  3,
+			Parallelism: 2,
+			SaltLength:  16,
+			KeyLength:   32,
+		},
+	}
+}
+
+// SetPasswordHasher validates hasher and makes models use it
+func SetPasswordHasher(h PasswordHasher) error {
+	err := h.Validate()
+	if err != nil {
+		return err
+	}
+
+	passwordHasher = h
+
+	return nil
+}
+
+// Validate checks algorithm and its parameters
+func (h PasswordHasher) Validate() error {
+	switch h.Algorithm {
+	case AlgorithmBcrypt:
+		if h.BcryptCost < bcrypt.MinCost || h.BcryptCost > bcrypt.MaxCost {
+			return fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+	case AlgorithmArgon2id:
+		if h.Argon2.Memory < 8*uint32(h.Argon2.Parallelism) || h.Argon2.Iterations < 1 || h.Argon2.Parallelism < 1 {
+			return errors.New("argon2id needs at least 1 iteration, 1 thread and 8 KiB of memory per thread")
+		}
+		if h.Argon2.SaltLength < 8 || h.Argon2.KeyLength < 16 {
+			return errors.New("argon2id salt must be at least 8 bytes and key at least 16 bytes")
+		}
+	default:
+		return fmt.Errorf("unknown password hash algorithm %q", h.Algorithm)
+	}
+
+	return nil
+}
+
+// Hash returns hash of password made with configured algorithm
+func (h PasswordHasher) Hash(password string) (string, error) {
+	if h.Algorithm == AlgorithmArgon2id {
+		return h.hashArgon2id(password)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.BcryptCost)
+	if err != nil {
+		return "", err
+	}
+
+	return string(hash), nil
+}
+
+// Matches checks password against bcrypt or argon2id hash
+func (h PasswordHasher) Matches(password, hash string) (bool, error) {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		params, salt, key, err := h.decodeArgon2id(hash)
+		if err != nil {
+			return false, err
+		}
+
+		other := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+
+		return subtle.ConstantTimeCompare(key, other) == 1, nil
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if err != nil {
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// NeedsRehash reports whether hash was made with other algorithm or weaker parameters than configured
+func (h PasswordHasher) NeedsRehash(hash string) bool {
+	if h.Algorithm == AlgorithmArgon2id {
+		params, _, _, err := h.decodeArgon2id(hash)
+		if err != nil {
+			return true
+		}
+
+		return params.Memory < h.Argon2.Memory || params.Iterations < h.Argon2.Iterations ||
+			params.Parallelism < h.Argon2.Parallelism || params.KeyLength < h.Argon2.KeyLength
+	}
+
+	cost, err := bcrypt.Cost([]byte(hash))
+	if err != nil {
+		return true
+	}
+
+	return cost < h.BcryptCost
+}
+
+// ValidHash checks that hash has format of supported algorithm
+func (h PasswordHasher) ValidHash(hash string) error {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		_, _, _, err := h.decodeArgon2id(hash)
+		return err
+	}
+
+	_, err := bcrypt.Cost([]byte(hash))
+	if err != nil {
+		return ErrUnknownHash
+	}
+
+	return nil
+}
+
+// hashArgon2id returns hash in PHC format $argon2id$v=19$m=65536,t=3,p=2$salt$key
+func (h PasswordHasher) hashArgon2id(password string) (string, error) {
+	salt := make([]byte, h.Argon2.SaltLength)
+
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+
+	p := h.Argon2
+	key := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		p.Memory,
+		p.Iterations,
+		p.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// decodeArgon2id returns parameters, salt and key of argon2id hash. Hashes with parameters which
+// argon2 can`t use or which are too costly to check are rejected
+func (h PasswordHasher) decodeArgon2id(hash string) (Argon2Params, []byte, []byte, error) {
+	var params Argon2Params
+
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != AlgorithmArgon2id {
+		return params, nil, nil, ErrUnknownHash
+	}
+
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return params, nil, nil, ErrUnknownHash
+	}
+
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism)
+	if err != nil {
+		return params, nil, nil, ErrUnknownHash
+	}
+
+	err = h.checkArgon2Params(params)
+	if err != nil {
+		return params, nil, nil, err
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrUnknownHash
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, ErrUnknownHash
+	}
+
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+
+	return params, salt, key, nil
+}
+
+// checkArgon2Params checks parameters of stored hash. argon2.IDKey panics on zero iterations or threads,
+// and memory or iterations above maxArgon2Factor times configured ones would make one login exhaust server
+func (h PasswordHasher) checkArgon2Params(p Argon2Params) error {
+	if p.Iterations < 1 || p.Parallelism < 1 || p.Memory < 8*uint32(p.Parallelism) {
+		return fmt.Errorf("%w: argon2id needs at least 1 iteration, 1 thread and 8 KiB of memory per thread",
+			ErrUnknownHash)
+	}
+
+	limit := DefaultPasswordHasher().Argon2
+
+	if uint64(p.Memory) > maxArgon2Factor*uint64(max(h.Argon2.Memory, limit.Memory)) ||
+		uint64(p.Iterations) > maxArgon2Factor*uint64(max(h.Argon2.Iterations, limit.Iterations)) ||
+		uint64(p.Parallelism) > maxArgon2Factor*uint64(max(h.Argon2.Parallelism, limit.Parallelism)) {
+		return fmt.Errorf("%w: argon2id parameters m=%d,t=%d,p=%d exceed limits", ErrUnknownHash,
+			p.Memory, p.Iterations, p.Parallelism)
+	}
+
+	return nil
+}
@@ -0,0 +1,125 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const oidcStateTTL = 10 * time.Minute
+
+var ErrInvalidState = errors.New("invalid or expired login state")
+
+// UserIdentity links user to account of external identity provider
+type UserIdentity struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"subject"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// OIDCState stores data of started external login which is needed to finish it
+type OIDCState struct {
+	Provider     string `json:"provider"`
+	CodeVerifier string `json:"code_verifier"`
+	Nonce        string `json:"nonce"`
+}
+
+// GetUserID returns ID of not deleted user linked to account of provider
+func (ui *UserIdentity) GetUserID(provider, subject string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select ui.user_id from user_identities ui
+		join users u on u.id = ui.user_id
+		where ui.provider = $1 and ui.subject = $2 and u.deleted_at is null`
+
+	var userID int
+
+	err := db.QueryRowContext(ctx, query, provider, subject).Scan(&userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// Link links user to account of provider
+func (ui *UserIdentity) Link(userID int, provider, subject, email string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `insert into user_identities (user_id, provider, subject, email, created_at)
+		values ($1, $2, $3, $4, $5)
+		on conflict (provider, subject) do nothing`
+
+	_, err := db.ExecContext(ctx, stmt, userID, provider, subject, email, time.Now())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CreateState stores state of started external login and returns random state value
+func (ui *UserIdentity) CreateState(state OIDCState) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	value, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	jsonData, err := json.Marshal(state)
+	if err != nil {
+		return "", err
+	}
+
+	err = rclient.Set(ctx, stateKey(value), jsonData, oidcStateTTL).Err()
+	if err != nil {
+		return "", err
+	}
+
+	return value, nil
+}
+
+// ConsumeState deletes state of external login and returns it. State can be consumed only once
+func (ui *UserIdentity) ConsumeState(value string) (*OIDCState, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	jsonData, err := rclient.GetDel(ctx, stateKey(value)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, ErrInvalidState
+		}
+		return nil, err
+	}
+
+	var state OIDCState
+
+	err = json.Unmarshal(jsonData, &state)
+	if err != nil {
+		return nil, err
+	}
+
+	return &state, nil
+}
+
+// RandomValue returns random value for nonce or password of users from external provider
+func (ui *UserIdentity) RandomValue() (string, error) {
+	return randomToken()
+}
+
+func stateKey(value string) string {
+	hash := sha256.Sum256([]byte(value))
+	return "oidc:state:" + hex.EncodeToString(hash[:])
+}
@@ -6,87 +6,140 @@ import (
 	"errors"
 	"fmt"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgconn"
+	"github.com/redis/go-redis/v9"
 	"log"
 	"time"
-
-	"golang.org/x/crypto/bcrypt"
 )
 
 const dbTimeout = time.Second * 3
 
 const key = "go-micro secure jwt key"
 
+var (
+	ErrDuplicateEmail = errors.New("user with this email already exists")
+	ErrNotDeleted     = errors.New("user not found or not deleted")
+	ErrEditConflict   = errors.New("user was changed by another request, read it again and retry")
+)
+
 var db *sql.DB
+var rclient *redis.Client
 
 // New create a new model
-func New(dbPool *sql.DB) Models {
+func New(dbPool *sql.DB, redisClient *redis.Client) Models {
 	db = dbPool
+	rclient = redisClient
 
 	return Models{
-		User:    User{},
-		UserJWT: UserJWT{},
+		User:           User{},
+		UserJWT:        UserJWT{},
+		Token:          Token{},
+		LoginAttempt:   LoginAttempt{},
+		TwoFactor:      TwoFactor{},
+		EmailChange:    EmailChange{},
+		UserIdentity:   UserIdentity{},
+		APIKey:         APIKey{},
+		PasswordPolicy: DefaultPasswordPolicy(),
 	}
 }
 
 // Models store all models service`s structure
 type Models struct {
-	User    User
-	UserJWT UserJWT
+	User           User
+	UserJWT        UserJWT
+	Token          Token
+	LoginAttempt   LoginAttempt
+	TwoFactor      TwoFactor
+	EmailChange    EmailChange
+	UserIdentity   UserIdentity
+	APIKey         APIKey
+	PasswordPolicy PasswordPolicy
 }
 
 // User store data of one user
 type User struct {
-	ID        int       `json:"id"`
-	Email     string    `json:"email"`
-	FirstName string    `json:"first_name,omitempty"`
-	LastName  string    `json:"last_name,omitempty"`
-	Password  string    `json:"password,omitempty"`
-	Active    int       `json:"active"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID               int        `json:"id"`
+	PublicID         string     `json:"public_id"`
+	Email            string     `json:"email"`
+	FirstName        string     `json:"first_name,omitempty"`
+	LastName         string     `json:"last_name,omitempty"`
+	Password         string     `json:"password,omitempty"`
+	Active           int        `json:"active"`
+	TokenVersion     int        `json:"-"`
+	TwoFactorEnabled bool       `json:"-"`
+	EmailVerified    bool       `json:"-"`
+	IsAdmin          bool       `json:"-"`
+	Version          int        `json:"version"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+	DeletedAt        *time.Time `json:"deleted_at,omitempty"`
 }
 
 type UserJWT struct {
 	jwt.RegisteredClaims
-	Email string `json:"email"`
+	UserID       string `json:"user_id"`
+	Email        string `json:"email"`
+	TokenVersion int    `json:"token_version"`
 }
 
-// GetAll returns all users
-func (u *User) GetAll() ([]*User, error) {
+// GetAll returns page of users matching filter and pagination metadata
+func (u *User) GetAll(filter UserFilter) ([]*User, Metadata, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	query := `select id, email, first_name, last_name, user_active, created_at, updated_at
-	from users order by last_name`
+	args := []any{filter.Active, filter.CreatedFrom, filter.CreatedTo, escapeLike(filter.Search), filter.Deleted}
 
-	rows, err := db.QueryContext(ctx, query)
+	query := `select count(*) over(), id, public_id, email, first_name, last_name, user_active, version,
+	created_at, updated_at, deleted_at
+	from users ` + usersFilterWhere + `
+	order by ` + filter.orderBy() + `
+	limit $6 offset $7`
+
+	rows, err := db.QueryContext(ctx, query, append(args, filter.limit(), filter.offset())...)
 	if err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
 	defer rows.Close()
 
-	var users []*User
+	totalRecords := 0
+	users := []*User{}
 
 	for rows.Next() {
 		var user User
 		err := rows.Scan(
+			&totalRecords,
 			&user.ID,
+			&user.PublicID,
 			&user.Email,
 			&user.FirstName,
 			&user.LastName,
 			&user.Active,
+			&user.Version,
 			&user.CreatedAt,
 			&user.UpdatedAt,
+			&user.DeletedAt,
 		)
 		if err != nil {
 			log.Println("Error scanning", err)
-			return nil, err
+			return nil, Metadata{}, err
 		}
 
 		users = append(users, &user)
 	}
 
-	return users, nil
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	// page after the last one has no rows, so total records are counted separately
+	if len(users) == 0 && filter.Page > 1 {
+		err = db.QueryRowContext(ctx, `select count(*) from users `+usersFilterWhere, args...).Scan(&totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+	}
+
+	return users, calculateMetadata(totalRecords, filter.Page, filter.PageSize), nil
 }
 
 // GetByEmail returns one user by email
@@ -94,17 +147,21 @@ func (u *User) GetByEmail(email string) (*User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	query := `select id, email, first_name, last_name, user_active, created_at, updated_at from users where email = $1`
+	query := `select id, public_id, email, first_name, last_name, user_active, is_admin, version, created_at,
+	updated_at from users where email = $1 and deleted_at is null`
 
 	var user User
 	row := db.QueryRowContext(ctx, query, email)
 
 	err := row.Scan(
 		&user.ID,
+		&user.PublicID,
 		&user.Email,
 		&user.FirstName,
 		&user.LastName,
 		&user.Active,
+		&user.IsAdmin,
+		&user.Version,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -121,18 +178,24 @@ func (u *User) GetByEmailWithPassword(email string) (*User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	query := `select id, email, first_name, last_name, password, user_active, created_at, updated_at from users where email = $1`
+	query := `select id, public_id, email, first_name, last_name, password, user_active, token_version, totp_enabled,
+	email_verified, version, created_at, updated_at from users where email = $1 and deleted_at is null`
 
 	var user User
 	row := db.QueryRowContext(ctx, query, email)
 
 	err := row.Scan(
 		&user.ID,
+		&user.PublicID,
 		&user.Email,
 		&user.FirstName,
 		&user.LastName,
 		&user.Password,
 		&user.Active,
+		&user.TokenVersion,
+		&user.TwoFactorEnabled,
+		&user.EmailVerified,
+		&user.Version,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -149,17 +212,21 @@ func (u *User) GetOne(id int) (*User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	query := `select id, email, first_name, last_name, user_active, created_at, updated_at from users where id = $1`
+	query := `select id, public_id, email, first_name, last_name, user_active, is_admin, version, created_at,
+	updated_at from users where id = $1 and deleted_at is null`
 
 	var user User
 	row := db.QueryRowContext(ctx, query, id)
 
 	err := row.Scan(
 		&user.ID,
+		&user.PublicID,
 		&user.Email,
 		&user.FirstName,
 		&user.LastName,
 		&user.Active,
+		&user.IsAdmin,
+		&user.Version,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -176,18 +243,24 @@ func (u *User) GetOneWithPassword(id int) (*User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	query := `select id, email, first_name, last_name, password, user_active, created_at, updated_at from users where id = $1`
+	query := `select id, public_id, email, first_name, last_name, password, user_active, token_version, totp_enabled,
+	email_verified, version, created_at, updated_at from users where id = $1 and deleted_at is null`
 
 	var user User
 	row := db.QueryRowContext(ctx, query, id)
 
 	err := row.Scan(
 		&user.ID,
+		&user.PublicID,
 		&user.Email,
 		&user.FirstName,
 		&user.LastName,
 		&user.Password,
 		&user.Active,
+		&user.TokenVersion,
+		&user.TwoFactorEnabled,
+		&user.EmailVerified,
+		&user.Version,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -199,7 +272,8 @@ func (u *User) GetOneWithPassword(id int) (*User, error) {
 	return &user, nil
 }
 
-// Update changes fields one user by ID
+// Update changes fields one user by ID if user was not changed since it was read.
+// Version of user is incremented
 func (u *User) Update() error {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
@@ -209,19 +283,45 @@ func (u *User) Update() error {
 		first_name = $2,
 		last_name = $3,
 		user_active = $4,
-		updated_at = $5
-		where id = $6
+		updated_at = $5,
+		version = version + 1
+		where id = $6 and version = $7 and deleted_at is null
+		returning version, updated_at
 	`
 
-	_, err := db.ExecContext(ctx, stmt,
+	err := db.QueryRowContext(ctx, stmt,
 		u.Email,
 		u.FirstName,
 		u.LastName,
 		u.Active,
 		time.Now(),
 		u.ID,
-	)
+		u.Version,
+	).Scan(&u.Version, &u.UpdatedAt)
 
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		case isUniqueViolation(err):
+			return ErrDuplicateEmail
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Activate marks user as active after email verification
+func (u *User) Activate() error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update users set user_active = 1, email_verified = true, updated_at = $1, version = version + 1
+		where id = $2 and deleted_at is null`
+
+	_, err := db.ExecContext(ctx, stmt, time.Now(), u.ID)
 	if err != nil {
 		return err
 	}
@@ -229,14 +329,20 @@ func (u *User) Update() error {
 	return nil
 }
 
-// Delete deletes one user by User.ID
+// Delete soft deletes one user by User.ID
 func (u *User) Delete() error {
+	return u.DeleteByID(u.ID)
+}
+
+// DeleteByID soft deletes one user by ID and revokes all sessions of user
+func (u *User) DeleteByID(id int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	stmt := `delete from users where id = $1`
+	stmt := `update users set deleted_at = $1, token_version = token_version + 1, updated_at = $1
+		where id = $2 and deleted_at is null`
 
-	_, err := db.ExecContext(ctx, stmt, u.ID)
+	_, err := db.ExecContext(ctx, stmt, time.Now(), id)
 	if err != nil {
 		return err
 	}
@@ -244,50 +350,113 @@ func (u *User) Delete() error {
 	return nil
 }
 
-// DeleteByID deletes one user by ID
-func (u *User) DeleteByID(id int) error {
+// Restore restores soft deleted user by ID
+func (u *User) Restore(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update users set deleted_at = null, updated_at = $1 where id = $2 and deleted_at is not null`
+
+	result, err := db.ExecContext(ctx, stmt, time.Now(), id)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrDuplicateEmail
+		}
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNotDeleted
+	}
+
+	return nil
+}
+
+// Erase permanently deletes user by ID, deleted or not, with all tokens and recovery codes
+func (u *User) Erase(id int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
 	stmt := `delete from users where id = $1`
 
-	_, err := db.ExecContext(ctx, stmt, id)
+	result, err := db.ExecContext(ctx, stmt, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
 	if err != nil {
 		return err
 	}
 
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
 	return nil
 }
 
-// Insert creates user
-func (u *User) Insert(user User) (int, error) {
+// GetOneIncludingDeleted returns ID, public ID and email of user by ID, deleted or not
+func (u *User) GetOneIncludingDeleted(id int) (*User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), 12)
+	var user User
+
+	query := `select id, public_id, email from users where id = $1`
+
+	err := db.QueryRowContext(ctx, query, id).Scan(&user.ID, &user.PublicID, &user.Email)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
-	var newID int
-	stmt := `insert into users (email, first_name, last_name, password, user_active, created_at, updated_at)
-		values ($1, $2, $3, $4, $5, $6, $7) returning id`
+	return &user, nil
+}
 
-	err = db.QueryRowContext(ctx, stmt,
-		user.Email,
-		user.FirstName,
-		user.LastName,
-		hashedPassword,
-		user.Active,
-		time.Now(),
-		time.Now(),
-	).Scan(&newID)
+// GetAllIdentities returns ID, public ID and email of all users, deleted or not
+func (u *User) GetAllIdentities() ([]*User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
 
+	rows, err := db.QueryContext(ctx, `select id, public_id, email from users order by id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*User
+
+	for rows.Next() {
+		var user User
+
+		err := rows.Scan(&user.ID, &user.PublicID, &user.Email)
+		if err != nil {
+			return nil, err
+		}
+
+		users = append(users, &user)
+	}
+
+	return users, rows.Err()
+}
+
+// Insert creates user
+func (u *User) Insert(user User) (int, error) {
+	if user.Password == "" {
+		return 0, ErrEmptyPassword
+	}
+
+	hashedPassword, err := passwordHasher.Hash(user.Password)
 	if err != nil {
 		return 0, err
 	}
 
-	return newID, nil
+	return u.InsertWithHash(user, hashedPassword)
 }
 
 // ResetPassword resets user`s password
@@ -295,7 +464,11 @@ func (u *User) ResetPassword(password string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	if password == "" {
+		return ErrEmptyPassword
+	}
+
+	hashedPassword, err := passwordHasher.Hash(password)
 	if err != nil {
 		return err
 	}
@@ -309,22 +482,61 @@ func (u *User) ResetPassword(password string) error {
 	return nil
 }
 
-// PasswordMatches checks password hash and password text
-func (u *User) PasswordMatches(plainText string) (bool, error) {
-	err := bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(plainText))
+// RevokeSessions makes all issued session tokens of user invalid
+func (u *User) RevokeSessions() error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update users set token_version = token_version + 1, updated_at = $1 where id = $2`
+	_, err := db.ExecContext(ctx, stmt, time.Now(), u.ID)
 	if err != nil {
-		switch {
-		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
-			// invalid password
-			return false, nil
-		default:
-			return false, err
+		return err
+	}
+
+	return nil
+}
+
+// PasswordMatches checks password hash and password text. Hash made with outdated algorithm
+// or cost is replaced with hash of configured algorithm after successful check
+func (u *User) PasswordMatches(plainText string) (bool, error) {
+	valid, err := passwordHasher.Matches(plainText, u.Password)
+	if err != nil || !valid {
+		// invalid password
+		return false, err
+	}
+
+	if passwordHasher.NeedsRehash(u.Password) {
+		// login must not fail because of upgrade, old hash is still valid
+		err = u.rehashPassword(plainText)
+		if err != nil {
+			log.Println("error upgrading password hash:", err)
 		}
 	}
 
 	return true, nil
 }
 
+// rehashPassword replaces hash of password if it wasn`t changed since user was read
+func (u *User) rehashPassword(plainText string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	hashedPassword, err := passwordHasher.Hash(plainText)
+	if err != nil {
+		return err
+	}
+
+	stmt := `update users set password = $1 where id = $2 and password = $3`
+	_, err = db.ExecContext(ctx, stmt, hashedPassword, u.ID, u.Password)
+	if err != nil {
+		return err
+	}
+
+	u.Password = hashedPassword
+
+	return nil
+}
+
 // TODO add CheckUserExists func realization
 // CheckUserExists checks if user exits or not
 func (u *User) CheckUserExists(email string) (bool, error) {
@@ -333,7 +545,7 @@ func (u *User) CheckUserExists(email string) (bool, error) {
 
 	var exists bool
 
-	query := `select exists(select email from users where email = $1)`
+	query := `select exists(select email from users where email = $1 and deleted_at is null)`
 
 	row := db.QueryRowContext(ctx, query, email)
 	err := row.Scan(exists)
@@ -347,14 +559,17 @@ func (u *User) CheckUserExists(email string) (bool, error) {
 }
 
 // CreateJWTToken creates jwt token for user
-func (uJWT *UserJWT) CreateJWTToken(email string) (string, error) {
+func (uJWT *UserJWT) CreateJWTToken(user *User) (string, error) {
 	exp := time.Now().Add(10 * time.Second)
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, UserJWT{
 		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   user.PublicID,
 			ExpiresAt: jwt.NewNumericDate(exp),
 		},
-		Email: email,
+		UserID:       user.PublicID,
+		Email:        user.Email,
+		TokenVersion: user.TokenVersion,
 	})
 
 	signedString, err := token.SignedString([]byte(key))
@@ -366,20 +581,43 @@ func (uJWT *UserJWT) CreateJWTToken(email string) (string, error) {
 	return signedString, nil
 }
 
-// CheckJWTToken checks valid token or not
-func (uJWT *UserJWT) CheckJWTToken(jwtToken string) (string, error) {
+// CheckJWTToken checks valid token or not and returns its claims
+func (uJWT *UserJWT) CheckJWTToken(jwtToken string) (*UserJWT, error) {
 	var userClaim UserJWT
 
 	token, err := jwt.ParseWithClaims(jwtToken, &userClaim, func(token *jwt.Token) (interface{}, error) {
 		return []byte(key), nil
 	})
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	if !token.Valid {
-		return "", errors.New("invalid token")
+		return nil, errors.New("invalid token")
 	}
 
-	return userClaim.Email, nil
+	// check that session was not revoked
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var tokenVersion int
+
+	query := `select token_version from users where public_id = $1 and email = $2 and deleted_at is null`
+
+	err = db.QueryRowContext(ctx, query, userClaim.UserID, userClaim.Email).Scan(&tokenVersion)
+	if err != nil {
+		return nil, errors.New("invalid token")
+	}
+
+	if tokenVersion != userClaim.TokenVersion {
+		return nil, errors.New("session is revoked")
+	}
+
+	return &userClaim, nil
+}
+
+// isUniqueViolation checks if postgres rejected query because of unique constraint
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
 }
@@ -0,0 +1,157 @@
+package data
+
+import (
+	"bufio"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+//go:embed breached_passwords.txt
+var bundledBreachedPasswords string
+
+var ErrEmptyPassword = errors.New("password must not be empty")
+
+// PasswordPolicy stores requirements to user`s password
+type PasswordPolicy struct {
+	MinLength     int
+	MaxLength     int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	breached      map[string]struct{}
+}
+
+// ValidationError stores all reasons why value is invalid
+type ValidationError struct {
+	Problems []string `json:"problems"`
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid password: " + strings.Join(e.Problems, "; ")
+}
+
+// DefaultPasswordPolicy returns policy with bundled list of breached passwords
+func DefaultPasswordPolicy() PasswordPolicy {
+	policy := PasswordPolicy{
+		MinLength:    8,
+		MaxLength:    72, // bcrypt uses only first 72 bytes
+		RequireUpper: true,
+		RequireLower: true,
+		RequireDigit: true,
+		breached:     make(map[string]struct{}),
+	}
+
+	policy.addBreached(strings.NewReader(bundledBreachedPasswords))
+
+	return policy
+}
+
+// LoadBreached adds SHA-1 hashes of breached passwords from file in format HASH or HASH:COUNT
+func (p *PasswordPolicy) LoadBreached(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return p.addBreached(f)
+}
+
+// Validate checks password against policy and returns *ValidationError with all problems
+func (p *PasswordPolicy) Validate(password, email string) error {
+	var problems []string
+
+	if utf8.RuneCountInString(password) < p.MinLength {
+		problems = append(problems, fmt.Sprintf("password must be at least %d characters long", p.MinLength))
+	}
+
+	if p.MaxLength > 0 && len(password) > p.MaxLength {
+		problems = append(problems, fmt.Sprintf("password must be at most %d bytes long", p.MaxLength))
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			hasSymbol = true
+		}
+	}
+
+	if p.RequireUpper && !hasUpper {
+		problems = append(problems, "password must contain an uppercase letter")
+	}
+	if p.RequireLower && !hasLower {
+		problems = append(problems, "password must contain a lowercase letter")
+	}
+	if p.RequireDigit && !hasDigit {
+		problems = append(problems, "password must contain a digit")
+	}
+	if p.RequireSymbol && !hasSymbol {
+		problems = append(problems, "password must contain a symbol")
+	}
+
+	if email != "" && strings.EqualFold(password, email) {
+		problems = append(problems, "password must not be equal to email")
+	}
+
+	if p.IsBreached(password) {
+		problems = append(problems, "password is too common or was found in a data breach")
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+
+	return nil
+}
+
+// IsBreached checks password against list of common and breached passwords
+func (p *PasswordPolicy) IsBreached(password string) bool {
+	if len(p.breached) == 0 {
+		return false
+	}
+
+	for _, candidate := range []string{password, strings.ToLower(password)} {
+		sum := sha1.Sum([]byte(candidate))
+		if _, ok := p.breached[strings.ToUpper(hex.EncodeToString(sum[:]))]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// addBreached reads hashes line by line, empty lines and comments are skipped
+func (p *PasswordPolicy) addBreached(r io.Reader) error {
+	if p.breached == nil {
+		p.breached = make(map[string]struct{})
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		hash, _, _ := strings.Cut(line, ":")
+		p.breached[strings.ToUpper(hash)] = struct{}{}
+	}
+
+	return scanner.Err()
+}
@@ -0,0 +1,206 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	ScopeVerification  = "verification"
+	ScopePasswordReset = "password_reset"
+	ScopeStream        = "stream"
+)
+
+const (
+	VerificationTTL  = 24 * time.Hour
+	PasswordResetTTL = time.Hour
+	// StreamTTL is short, because stream token is sent in URL where headers can`t be set
+	StreamTTL = time.Minute
+)
+
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+// Token stores hash of single-use token of user
+type Token struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	Hash      []byte    `json:"-"`
+	Scope     string    `json:"scope"`
+	Expiry    time.Time `json:"expiry"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// VerificationClaims stores claims of signed email verification token
+type VerificationClaims struct {
+	jwt.RegisteredClaims
+	Email string `json:"email"`
+}
+
+// New creates random token for user, stores its hash and returns plain text token
+func (t *Token) New(userID int, scope string, ttl time.Duration) (string, error) {
+	plainText, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = t.Insert(userID, plainText, scope, ttl)
+	if err != nil {
+		return "", err
+	}
+
+	return plainText, nil
+}
+
+// Insert stores hash of plain text token for user
+func (t *Token) Insert(userID int, plainText, scope string, ttl time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	hash := sha256.Sum256([]byte(plainText))
+
+	stmt := `insert into tokens (user_id, token_hash, scope, expiry, created_at)
+		values ($1, $2, $3, $4, $5)`
+
+	_, err := db.ExecContext(ctx, stmt,
+		userID,
+		hash[:],
+		scope,
+		time.Now().Add(ttl),
+		time.Now(),
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Consume deletes not expired token and returns ID of its user. Token can be consumed only once
+func (t *Token) Consume(plainText, scope string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	hash := sha256.Sum256([]byte(plainText))
+
+	stmt := `delete from tokens where token_hash = $1 and scope = $2 and expiry > $3 returning user_id`
+
+	var userID int
+	err := db.QueryRowContext(ctx, stmt, hash[:], scope, time.Now()).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrInvalidToken
+		}
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// GetUserID returns ID of user of not expired token without consuming it
+func (t *Token) GetUserID(plainText, scope string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	hash := sha256.Sum256([]byte(plainText))
+
+	query := `select user_id from tokens where token_hash = $1 and scope = $2 and expiry > $3`
+
+	var userID int
+	err := db.QueryRowContext(ctx, query, hash[:], scope, time.Now()).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrInvalidToken
+		}
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// DeleteAllForUser deletes all user`s tokens with scope
+func (t *Token) DeleteAllForUser(userID int, scope string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `delete from tokens where user_id = $1 and scope = $2`
+
+	_, err := db.ExecContext(ctx, stmt, userID, scope)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CreateVerificationToken creates signed single-use token to verify user`s email
+func (t *Token) CreateVerificationToken(userID int, email string) (string, error) {
+	jti, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = t.Insert(userID, jti, ScopeVerification, VerificationTTL)
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, VerificationClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Subject:   strconv.Itoa(userID),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(VerificationTTL)),
+		},
+		Email: email,
+	})
+
+	signedString, err := token.SignedString([]byte(key))
+	if err != nil {
+		return "", fmt.Errorf("error creating signed string: %v", err)
+	}
+
+	return signedString, nil
+}
+
+// ConsumeVerificationToken checks signature of verification token, consumes it and returns ID of user
+func (t *Token) ConsumeVerificationToken(verificationToken string) (int, error) {
+	var claims VerificationClaims
+
+	token, err := jwt.ParseWithClaims(verificationToken, &claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(key), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil || !token.Valid {
+		return 0, ErrInvalidToken
+	}
+
+	userID, err := t.Consume(claims.ID, ScopeVerification)
+	if err != nil {
+		return 0, err
+	}
+
+	if strconv.Itoa(userID) != claims.Subject {
+		return 0, ErrInvalidToken
+	}
+
+	return userID, nil
+}
+
+// randomToken generates random plain text token
+func randomToken() (string, error) {
+	randomBytes := make([]byte, 16)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes), nil
+}
@@ -0,0 +1,340 @@
+package data
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	totpIssuer         = "go-micro"
+	totpDigits         = 6
+	totpPeriod         = 30
+	totpSkew           = 1
+	recoveryCodesCount = 10
+	challengeTTL       = 5 * time.Minute
+	maxChallengeTries  = 5
+)
+
+var ErrInvalidChallenge = errors.New("invalid or expired two-factor challenge")
+
+// TwoFactor stores TOTP settings and recovery codes of user
+type TwoFactor struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+// GenerateSecret creates new TOTP secret and otpauth URI to show it as QR code
+func (tf *TwoFactor) GenerateSecret(email string) (*TwoFactor, error) {
+	randomBytes := make([]byte, 20)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", totpIssuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", strconv.Itoa(totpDigits))
+	params.Set("period", strconv.Itoa(totpPeriod))
+
+	uri := fmt.Sprintf("otpauth://totp/%s:%s?%s",
+		url.PathEscape(totpIssuer), url.PathEscape(email), params.Encode())
+
+	return &TwoFactor{
+		Secret:     secret,
+		OTPAuthURI: uri,
+	}, nil
+}
+
+// SetPendingSecret stores not confirmed TOTP secret of user
+func (tf *TwoFactor) SetPendingSecret(userID int, secret string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update users set totp_secret = $1, totp_enabled = false, updated_at = $2 where id = $3`
+
+	_, err := db.ExecContext(ctx, stmt, secret, time.Now(), userID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetSecret returns TOTP secret of user and whether two-factor authentication is enabled
+func (tf *TwoFactor) GetSecret(userID int) (string, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select totp_secret, totp_enabled from users where id = $1`
+
+	var secret string
+	var enabled bool
+
+	err := db.QueryRowContext(ctx, query, userID).Scan(&secret, &enabled)
+	if err != nil {
+		return "", false, err
+	}
+
+	return secret, enabled, nil
+}
+
+// Enable turns on two-factor authentication of user after confirmation of secret
+func (tf *TwoFactor) Enable(userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update users set totp_enabled = true, updated_at = $1 where id = $2 and totp_secret <> ''`
+
+	_, err := db.ExecContext(ctx, stmt, time.Now(), userID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ValidateCode checks TOTP code of user. Every code can be used only once
+func (tf *TwoFactor) ValidateCode(userID int, secret, code string) (bool, error) {
+	step, ok := matchTOTP(secret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	// reject replay of already used code
+	key := fmt.Sprintf("2fa:last:%d", userID)
+
+	last, err := rclient.Get(ctx, key).Int64()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return false, err
+	}
+
+	if err == nil && step <= last {
+		return false, nil
+	}
+
+	err = rclient.Set(ctx, key, step, 2*(totpSkew+1)*totpPeriod*time.Second).Err()
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// CreateRecoveryCodes replaces recovery codes of user and returns new plain text codes
+func (tf *TwoFactor) CreateRecoveryCodes(userID int) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `delete from recovery_codes where user_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := `insert into recovery_codes (user_id, code_hash, created_at) values ($1, $2, $3)`
+
+	codes := make([]string, 0, recoveryCodesCount)
+
+	for i := 0; i < recoveryCodesCount; i++ {
+		code, err := randomRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+
+		hash := sha256.Sum256([]byte(code))
+
+		_, err = tx.ExecContext(ctx, stmt, userID, hash[:], time.Now())
+		if err != nil {
+			return nil, err
+		}
+
+		codes = append(codes, code)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// UseRecoveryCode deletes recovery code of user and returns true if code existed
+func (tf *TwoFactor) UseRecoveryCode(userID int, code string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	// code is accepted with or without dash and spaces
+	code = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	if len(code) == 10 {
+		code = code[:5] + "-" + code[5:]
+	}
+
+	hash := sha256.Sum256([]byte(code))
+
+	stmt := `delete from recovery_codes where user_id = $1 and code_hash = $2 returning id`
+
+	var id int
+	err := db.QueryRowContext(ctx, stmt, userID, hash[:]).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// CreateChallenge creates short-lived challenge which must be completed with second factor
+func (tf *TwoFactor) CreateChallenge(userID int) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	challenge, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = rclient.Set(ctx, challengeKey(challenge), userID, challengeTTL).Err()
+	if err != nil {
+		return "", err
+	}
+
+	return challenge, nil
+}
+
+// TryChallenge counts try of challenge and returns ID of user who has to complete it. Try is
+// counted before code is checked, so challenge is rejected after maxChallengeTries even for
+// parallel tries
+func (tf *TwoFactor) TryChallenge(challenge string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	key := challengeKey(challenge) + ":tries"
+
+	tries, err := rclient.Incr(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	if tries == 1 {
+		err = rclient.Expire(ctx, key, challengeTTL).Err()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if tries > maxChallengeTries {
+		err = rclient.Del(ctx, challengeKey(challenge)).Err()
+		if err != nil {
+			return 0, err
+		}
+		return 0, ErrInvalidChallenge
+	}
+
+	userID, err := rclient.Get(ctx, challengeKey(challenge)).Int()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return 0, ErrInvalidChallenge
+		}
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// DeleteChallenge deletes completed challenge
+func (tf *TwoFactor) DeleteChallenge(challenge string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	return rclient.Del(ctx, challengeKey(challenge), challengeKey(challenge)+":tries").Err()
+}
+
+// matchTOTP checks code against TOTP of secret around time t and returns matched time step
+func matchTOTP(secret, code string, t time.Time) (int64, bool) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := t.Unix() / totpPeriod
+
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		expected := totpCode(key, step)
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+// totpCode calculates code for time step as described in RFC 6238
+func totpCode(key []byte, step int64) string {
+	var message [8]byte
+	binary.BigEndian.PutUint64(message[:], uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(message[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", totpDigits, value%mod)
+}
+
+// randomRecoveryCode generates recovery code like ABCDE-FGHIJ
+func randomRecoveryCode() (string, error) {
+	randomBytes := make([]byte, 10)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	code := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)[:10]
+
+	return code[:5] + "-" + code[5:], nil
+}
+
+func challengeKey(challenge string) string {
+	hash := sha256.Sum256([]byte(challenge))
+	return "2fa:challenge:" + hex.EncodeToString(hash[:])
+}
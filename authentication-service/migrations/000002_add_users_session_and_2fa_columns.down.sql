@@ -0,0 +1,3 @@
+alter table users drop column if exists totp_enabled;
+alter table users drop column if exists totp_secret;
+alter table users drop column if exists token_version;
@@ -0,0 +1,3 @@
+alter table users add column if not exists token_version integer not null default 0;
+alter table users add column if not exists totp_secret varchar(64) not null default '';
+alter table users add column if not exists totp_enabled boolean not null default false;
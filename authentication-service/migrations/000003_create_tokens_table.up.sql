@@ -0,0 +1,11 @@
+create table if not exists tokens (
+    id bigserial primary key,
+    user_id integer not null references users (id) on delete cascade,
+    token_hash bytea not null,
+    scope varchar(32) not null,
+    expiry timestamp not null,
+    created_at timestamp not null default now()
+);
+
+create unique index if not exists tokens_token_hash_scope_idx on tokens (token_hash, scope);
+create index if not exists tokens_user_id_scope_idx on tokens (user_id, scope);
@@ -0,0 +1,8 @@
+create table if not exists recovery_codes (
+    id bigserial primary key,
+    user_id integer not null references users (id) on delete cascade,
+    code_hash bytea not null,
+    created_at timestamp not null default now()
+);
+
+create index if not exists recovery_codes_user_id_idx on recovery_codes (user_id);
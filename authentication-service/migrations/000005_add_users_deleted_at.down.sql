@@ -0,0 +1,4 @@
+drop index if exists users_email_not_deleted_idx;
+delete from users where deleted_at is not null;
+alter table users add constraint users_email_key unique (email);
+alter table users drop column if exists deleted_at;
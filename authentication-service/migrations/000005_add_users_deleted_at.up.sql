@@ -0,0 +1,5 @@
+alter table users add column if not exists deleted_at timestamp;
+
+-- email stays unique only among not deleted users
+alter table users drop constraint if exists users_email_key;
+create unique index if not exists users_email_not_deleted_idx on users (email) where deleted_at is null;
@@ -0,0 +1,8 @@
+create table if not exists email_changes (
+    id bigserial primary key,
+    user_id integer not null unique references users (id) on delete cascade,
+    new_email varchar(255) not null,
+    token_hash bytea not null unique,
+    expiry timestamp not null,
+    created_at timestamp not null default now()
+);
@@ -0,0 +1,2 @@
+drop index if exists users_public_id_idx;
+alter table users drop column if exists public_id;
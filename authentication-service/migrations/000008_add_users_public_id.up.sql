@@ -0,0 +1,3 @@
+-- existing users get generated public ID too
+alter table users add column if not exists public_id uuid not null default gen_random_uuid();
+create unique index if not exists users_public_id_idx on users (public_id);
@@ -0,0 +1,11 @@
+create table if not exists user_identities (
+    id bigserial primary key,
+    user_id integer not null references users (id) on delete cascade,
+    provider varchar(64) not null,
+    subject varchar(255) not null,
+    email varchar(255) not null,
+    created_at timestamp not null default now(),
+    unique (provider, subject)
+);
+
+create index if not exists user_identities_user_id_idx on user_identities (user_id);
@@ -0,0 +1,13 @@
+create table if not exists api_keys (
+    id bigserial primary key,
+    user_id integer not null references users (id) on delete cascade,
+    name varchar(255) not null,
+    prefix varchar(16) not null,
+    key_hash bytea not null unique,
+    scopes text not null,
+    created_at timestamp not null default now(),
+    last_used_at timestamp,
+    revoked_at timestamp
+);
+
+create index if not exists api_keys_user_id_idx on api_keys (user_id);
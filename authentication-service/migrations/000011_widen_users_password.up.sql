@@ -0,0 +1,2 @@
+-- argon2id hashes are longer than bcrypt ones
+alter table users alter column password type varchar(255);
@@ -0,0 +1,2 @@
+-- admins are granted users:admin and logs scopes, flag is set only in database
+alter table users add column if not exists is_admin boolean not null default false;
@@ -0,0 +1,3 @@
+-- user_active is also changed by admins, so verification of email is stored apart
+alter table users add column if not exists email_verified boolean not null default false;
+update users set email_verified = true where user_active = 1;
@@ -0,0 +1,246 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lockID is key of postgres advisory lock, so only one replica migrates at a time
+const lockID = 727_001
+
+const migrateTimeout = time.Minute
+
+//go:embed *.sql
+var files embed.FS
+
+// Migration stores up and down SQL of one schema version
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Load returns all embedded migrations sorted by version
+func Load() ([]Migration, error) {
+	names, err := fs.Glob(files, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+
+	for _, fileName := range names {
+		// file name looks like 000001_create_users_table.up.sql
+		base := strings.TrimSuffix(fileName, ".sql")
+
+		direction := base[strings.LastIndex(base, ".")+1:]
+		base = strings.TrimSuffix(base, "."+direction)
+
+		versionPart, name, ok := strings.Cut(base, "_")
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("invalid migration file name %s", fileName)
+		}
+
+		version, err := strconv.Atoi(versionPart)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %s: %v", fileName, err)
+		}
+
+		content, err := files.ReadFile(fileName)
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+
+		if direction == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %06d_%s must have up and down files", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// Up applies not applied migrations. If steps is 0 all of them are applied
+func Up(db *sql.DB, steps int) error {
+	return run(db, func(ctx context.Context, conn *sql.Conn, migrations []Migration, current int) error {
+		applied := 0
+
+		for _, m := range migrations {
+			if m.Version <= current {
+				continue
+			}
+			if steps > 0 && applied == steps {
+				break
+			}
+
+			err := apply(ctx, conn, m.Up, `insert into schema_version (version, name, applied_at) values ($1, $2, $3)`,
+				m.Version, m.Name, time.Now())
+			if err != nil {
+				return fmt.Errorf("migration %06d_%s up: %v", m.Version, m.Name, err)
+			}
+
+			log.Printf("Applied migration %06d_%s", m.Version, m.Name)
+			applied++
+		}
+
+		if applied == 0 {
+			log.Println("Database schema is up to date, version", current)
+		}
+
+		return nil
+	})
+}
+
+// Down rolls back applied migrations. If steps is 0 all of them are rolled back
+func Down(db *sql.DB, steps int) error {
+	return run(db, func(ctx context.Context, conn *sql.Conn, migrations []Migration, current int) error {
+		rolledBack := 0
+
+		for i := len(migrations) - 1; i >= 0; i-- {
+			m := migrations[i]
+
+			if m.Version > current {
+				continue
+			}
+			if steps > 0 && rolledBack == steps {
+				break
+			}
+
+			err := apply(ctx, conn, m.Down, `delete from schema_version where version = $1`, m.Version)
+			if err != nil {
+				return fmt.Errorf("migration %06d_%s down: %v", m.Version, m.Name, err)
+			}
+
+			log.Printf("Rolled back migration %06d_%s", m.Version, m.Name)
+			rolledBack++
+		}
+
+		return nil
+	})
+}
+
+// Version returns the latest applied schema version
+func Version(db *sql.DB) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), migrateTimeout)
+	defer cancel()
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	err = createVersionTable(ctx, conn)
+	if err != nil {
+		return 0, err
+	}
+
+	return currentVersion(ctx, conn)
+}
+
+// run holds advisory lock during migration, so replicas started at once do not migrate concurrently
+func run(db *sql.DB, migrate func(context.Context, *sql.Conn, []Migration, int) error) error {
+	migrations, err := Load()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), migrateTimeout)
+	defer cancel()
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, `select pg_advisory_lock($1)`, lockID)
+	if err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.Background(), `select pg_advisory_unlock($1)`, lockID)
+
+	err = createVersionTable(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	current, err := currentVersion(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	return migrate(ctx, conn, migrations, current)
+}
+
+// apply executes migration and updates schema version in one transaction
+func apply(ctx context.Context, conn *sql.Conn, migration, versionStmt string, args ...any) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, migration)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, versionStmt, args...)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func createVersionTable(ctx context.Context, conn *sql.Conn) error {
+	stmt := `create table if not exists schema_version (
+		version integer primary key,
+		name varchar(255) not null,
+		applied_at timestamp not null
+	)`
+
+	_, err := conn.ExecContext(ctx, stmt)
+
+	return err
+}
+
+func currentVersion(ctx context.Context, conn *sql.Conn) (int, error) {
+	var version int
+
+	err := conn.QueryRowContext(ctx, `select coalesce(max(version), 0) from schema_version`).Scan(&version)
+	if err != nil {
+		return 0, err
+	}
+
+	return version, nil
+}
@@ -5,14 +5,18 @@ import (
 	"broker/logs"
 	"bytes"
 	"context"
+	"encoding/gob"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"io"
 	"log"
 	"net/http"
 	"net/rpc"
+	"net/url"
 	"time"
 )
 
@@ -22,12 +26,21 @@ type RequestPayload struct {
 	Auth           AuthUserPayload       `json:"auth,omitempty"`
 	Session        SessionTokenPayload   `json:"session,omitempty"`
 	Reg            RegUserPayload        `json:"reg,omitempty"`
+	Token          TokenPayload          `json:"token,omitempty"`
 	UpdateUser     UpdateUserPayload     `json:"update_user,omitempty"`
 	ChangePassword ChangePasswordPayload `json:"change_password,omitempty"`
+	ResetPassword  ResetPasswordPayload  `json:"reset_password,omitempty"`
 	Email          EmailPayload          `json:"email,omitempty"`
 	ID             IDPayload             `json:"id,omitempty"`
 	Log            LogPayload            `json:"log,omitempty"`
 	Mail           MailPayload           `json:"mail,omitempty"`
+	TwoFactor      TwoFactorPayload      `json:"two_factor,omitempty"`
+	Filter         UserFilterPayload     `json:"filter,omitempty"`
+	OIDC           OIDCPayload           `json:"oidc,omitempty"`
+	APIKey         APIKeyPayload         `json:"api_key,omitempty"`
+	ImportUsers    ImportUsersPayload    `json:"import_users,omitempty"`
+	LogFilter      LogFilterPayload      `json:"log_filter,omitempty"`
+	LogStats       LogStatsPayload       `json:"log_stats,omitempty"`
 }
 
 // MailPayload stores data to send mail to user
@@ -42,6 +55,7 @@ type MailPayload struct {
 type AuthUserPayload struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	IP       string `json:"ip,omitempty"`
 }
 
 // SessionTokenPayload stores token of user`s session
@@ -55,16 +69,21 @@ type RegUserPayload struct {
 	FirstName string `json:"first_name,omitempty"`
 	LastName  string `json:"last_name,omitempty"`
 	Password  string `json:"password"`
-	Active    int    `json:"active"`
+}
+
+// TokenPayload stores single-use token sent to user`s email
+type TokenPayload struct {
+	Token string `json:"token"`
 }
 
 // UpdateUserPayload stores data to update user
 type UpdateUserPayload struct {
-	Email       string `json:"email"`
-	EmailChange string `json:"email_change"`
-	FirstName   string `json:"first_name,omitempty,omitempty"`
-	LastName    string `json:"last_name,omitempty,omitempty"`
-	Active      int    `json:"active,omitempty"`
+	Email       string  `json:"email"`
+	EmailChange *string `json:"email_change,omitempty"`
+	FirstName   *string `json:"first_name,omitempty"`
+	LastName    *string `json:"last_name,omitempty"`
+	Active      *int    `json:"active,omitempty"`
+	Version     *int    `json:"version,omitempty"`
 }
 
 // ChangePasswordPayload stores data to change password
@@ -74,6 +93,12 @@ type ChangePasswordPayload struct {
 	NewPassword string `json:"new_password"`
 }
 
+// ResetPasswordPayload stores data to reset forgotten password
+type ResetPasswordPayload struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
 // EmailPayload stores data of email
 type EmailPayload struct {
 	Email string `json:"email"`
@@ -84,16 +109,107 @@ type IDPayload struct {
 	ID int `json:"id"`
 }
 
-// LogPayload stores log data
+// LogPayload stores log data, all fields except name and data are optional
 type LogPayload struct {
-	Name string `json:"name"`
-	Data string `json:"data"`
+	Name      string         `json:"name"`
+	Data      string         `json:"data"`
+	Level     string         `json:"level,omitempty"`
+	Service   string         `json:"service,omitempty"`
+	TraceID   string         `json:"trace_id,omitempty"`
+	UserID    string         `json:"user_id,omitempty"`
+	Fields    map[string]any `json:"fields,omitempty"`
+	Timestamp *time.Time     `json:"timestamp,omitempty"`
+}
+
+// TwoFactorPayload stores data of two-factor authentication
+type TwoFactorPayload struct {
+	SessionToken string `json:"session_token,omitempty"`
+	Challenge    string `json:"challenge,omitempty"`
+	Code         string `json:"code,omitempty"`
+}
+
+// UserFilterPayload stores pagination, filters and sort order of users list
+type UserFilterPayload struct {
+	Page        int    `json:"page,omitempty"`
+	PageSize    int    `json:"page_size,omitempty"`
+	Active      *int   `json:"active,omitempty"`
+	CreatedFrom string `json:"created_from,omitempty"`
+	CreatedTo   string `json:"created_to,omitempty"`
+	Search      string `json:"search,omitempty"`
+	Sort        string `json:"sort,omitempty"`
+	Deleted     bool   `json:"deleted,omitempty"`
+}
+
+// OIDCPayload stores data of login with external identity provider
+type OIDCPayload struct {
+	Provider string `json:"provider,omitempty"`
+	State    string `json:"state,omitempty"`
+	Code     string `json:"code,omitempty"`
+}
+
+// APIKeyPayload stores data to manage API keys of user
+type APIKeyPayload struct {
+	SessionToken string   `json:"session_token"`
+	Name         string   `json:"name,omitempty"`
+	Scopes       []string `json:"scopes,omitempty"`
+	ID           int      `json:"id,omitempty"`
+}
+
+// ImportUsersPayload stores CSV or JSON with users to import
+type ImportUsersPayload struct {
+	Format string `json:"format"`
+	Data   string `json:"data"`
+	DryRun bool   `json:"dry_run"`
+}
+
+// LogFilterPayload stores filters, sort order and cursor of logs list
+type LogFilterPayload struct {
+	Name    string `json:"name,omitempty"`
+	Level   string `json:"level,omitempty"`
+	Service string `json:"service,omitempty"`
+	UserID  string `json:"user_id,omitempty"`
+	TraceID string `json:"trace_id,omitempty"`
+	From    string `json:"from,omitempty"`
+	To      string `json:"to,omitempty"`
+	Search  string `json:"search,omitempty"`
+	Sort    string `json:"sort,omitempty"`
+	Limit   int    `json:"limit,omitempty"`
+	Cursor  string `json:"cursor,omitempty"`
+}
+
+// LogStatsPayload stores grouping, time interval and filters of log statistics
+type LogStatsPayload struct {
+	GroupBy  []string `json:"group_by,omitempty"`
+	Interval string   `json:"interval,omitempty"`
+	Timezone string   `json:"timezone,omitempty"`
+	Top      int      `json:"top,omitempty"`
+	Name     string   `json:"name,omitempty"`
+	Level    string   `json:"level,omitempty"`
+	Service  string   `json:"service,omitempty"`
+	UserID   string   `json:"user_id,omitempty"`
+	TraceID  string   `json:"trace_id,omitempty"`
+	From     string   `json:"from,omitempty"`
+	To       string   `json:"to,omitempty"`
+	Search   string   `json:"search,omitempty"`
 }
 
 // RPCPayload stores log data RPC
 type RPCPayload struct {
-	Name string
-	Data string
+	Name      string
+	Data      string
+	Level     string
+	Service   string
+	Timestamp time.Time
+	TraceID   string
+	UserID    string
+	Fields    map[string]any
+}
+
+// nested objects and arrays of Fields are decoded from JSON into these types, gob can send them
+// as values of interface only when they are registered
+func init() {
+	gob.Register(map[string]any{})
+	gob.Register([]any{})
 }
 
 // Broker returns simple message of json
@@ -116,19 +232,39 @@ func (app *Config) HandleSubmission(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	status, err := app.authorize(r, requestPayload.Action)
+	if err != nil {
+		app.errorJSON(w, err, status)
+		return
+	}
+
+	status, err = authorizeOwner(r, requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, status)
+		return
+	}
+
 	switch requestPayload.Action {
 	case "authenticate_user":
+		// ip is taken from connection or headers of trusted proxy only, so client can`t bypass limit of failed attempts
+		requestPayload.Auth.IP = clientIP(r)
 		app.authenticateUserViaRabbit(w, requestPayload.Auth)
 	case "authenticate_user_session":
 		app.authenticateUserSessionViaRabbit(w, requestPayload.Session)
 	case "registration_user":
 		app.registrationUserViaRabbit(w, requestPayload.Reg)
+	case "verify_email":
+		app.verifyEmailViaRabbit(w, requestPayload.Token)
 	case "update_user":
 		app.updateUserViaRabbit(w, requestPayload.UpdateUser)
 	case "change_password":
 		app.changePasswordViaRabbit(w, requestPayload.ChangePassword)
+	case "request_password_reset":
+		app.requestPasswordResetViaRabbit(w, requestPayload.Email)
+	case "confirm_password_reset":
+		app.confirmPasswordResetViaRabbit(w, requestPayload.ResetPassword)
 	case "get_all_users":
-		app.getAllUsersViaRabbit(w)
+		app.getAllUsersViaRabbit(w, requestPayload.Filter)
 	case "get_user_by_email":
 		app.getUserByEmailViaRabbit(w, requestPayload.Email)
 	case "get_user_by_id":
@@ -137,6 +273,38 @@ func (app *Config) HandleSubmission(w http.ResponseWriter, r *http.Request) {
 		app.deleteUserByEmailViaRabbit(w, requestPayload.Email)
 	case "delete_user_by_id":
 		app.deleteUserByIDViaRabbit(w, requestPayload.ID)
+	case "unlock_user":
+		app.unlockUserViaRabbit(w, requestPayload.Email)
+	case "enroll_2fa":
+		app.enroll2FAViaRabbit(w, requestPayload.TwoFactor)
+	case "confirm_2fa":
+		app.confirm2FAViaRabbit(w, requestPayload.TwoFactor)
+	case "authenticate_user_2fa":
+		app.authenticateUser2FAViaRabbit(w, requestPayload.TwoFactor)
+	case "restore_user":
+		app.restoreUserViaRabbit(w, requestPayload.ID)
+	case "erase_user":
+		app.eraseUserViaRabbit(w, requestPayload.ID)
+	case "confirm_email_change":
+		app.confirmEmailChangeViaRabbit(w, requestPayload.Token)
+	case "oidc_start":
+		app.oidcStartViaRabbit(w, requestPayload.OIDC)
+	case "oidc_callback":
+		app.oidcCallbackViaRabbit(w, requestPayload.OIDC)
+	case "create_api_key":
+		app.createAPIKeyViaRabbit(w, requestPayload.APIKey)
+	case "get_api_keys":
+		app.getAPIKeysViaRabbit(w, requestPayload.APIKey)
+	case "revoke_api_key":
+		app.revokeAPIKeyViaRabbit(w, requestPayload.APIKey)
+	case "import_users":
+		app.importUsersViaRabbit(w, requestPayload.ImportUsers)
+	case "get_logs":
+		app.getLogsViaRabbit(w, requestPayload.LogFilter)
+	case "get_log_stats":
+		app.getLogStatsViaRabbit(w, requestPayload.LogStats)
+	case "verify_audit":
+		app.verifyAuditViaRabbit(w)
 	case "log":
 		app.logEventViaRabbit(w, requestPayload.Log)
 	case "mail":
@@ -257,7 +425,7 @@ func (app *Config) updateUser(w http.ResponseWriter, u UpdateUserPayload) {
 	jsonData, _ := json.MarshalIndent(u, "", "\t")
 
 	// call the service
-	request, err := http.NewRequest("PUT", "http://authentication-service/update", bytes.NewBuffer(jsonData))
+	request, err := http.NewRequest("PATCH", "http://authentication-service/update", bytes.NewBuffer(jsonData))
 	if err != nil {
 		app.errorJSON(w, err)
 		return
@@ -355,10 +523,13 @@ func (app *Config) changePassword(w http.ResponseWriter, cp ChangePasswordPayloa
 	app.writeJSON(w, http.StatusOK, payload)
 }
 
-// getAllUsers returns all users
-func (app *Config) getAllUsers(w http.ResponseWriter) {
+// getAllUsers returns page of users
+func (app *Config) getAllUsers(w http.ResponseWriter, f UserFilterPayload) {
+	// create some json we'll send to the auth microservice
+	jsonData, _ := json.MarshalIndent(f, "", "\t")
+
 	// call the service
-	request, err := http.NewRequest("GET", "http://authentication-service/get_all", nil)
+	request, err := http.NewRequest("POST", "http://authentication-service/get_all", bytes.NewBuffer(jsonData))
 	if err != nil {
 		app.errorJSON(w, err)
 		return
@@ -827,11 +998,12 @@ func (app *Config) authenticateUserSessionViaRabbit(w http.ResponseWriter, st Se
 	app.writeJSON(w, http.StatusOK, payload)
 }
 
-// getAllUsersViaRabbit returns all users via RabbitMQ
-func (app *Config) getAllUsersViaRabbit(w http.ResponseWriter) {
+// getAllUsersViaRabbit returns page of users via RabbitMQ
+func (app *Config) getAllUsersViaRabbit(w http.ResponseWriter, f UserFilterPayload) {
 	var requestPayload RequestPayload
 
 	requestPayload.Action = "get_all_users"
+	requestPayload.Filter = f
 
 	response, err := app.pushToQueue(requestPayload)
 	if err != nil {
@@ -922,6 +1094,30 @@ func (app *Config) registrationUserViaRabbit(w http.ResponseWriter, r RegUserPay
 	app.writeJSON(w, http.StatusCreated, payload)
 }
 
+// verifyEmailViaRabbit activates user by verification token via RabbitMQ
+func (app *Config) verifyEmailViaRabbit(w http.ResponseWriter, t TokenPayload) {
+	var requestPayload RequestPayload
+
+	requestPayload.Action = "verify_email"
+	requestPayload.Token = t
+
+	response, err := app.pushToQueue(requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	var payload jsonResponse
+
+	err = json.Unmarshal(response, &payload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
 // updateUser updates user`s fields via RabbitMQ
 func (app *Config) updateUserViaRabbit(w http.ResponseWriter, u UpdateUserPayload) {
 	var requestPayload RequestPayload
@@ -970,6 +1166,54 @@ func (app *Config) changePasswordViaRabbit(w http.ResponseWriter, cp ChangePassw
 	app.writeJSON(w, http.StatusOK, payload)
 }
 
+// requestPasswordResetViaRabbit sends email with link to reset password via RabbitMQ
+func (app *Config) requestPasswordResetViaRabbit(w http.ResponseWriter, e EmailPayload) {
+	var requestPayload RequestPayload
+
+	requestPayload.Action = "request_password_reset"
+	requestPayload.Email = e
+
+	response, err := app.pushToQueue(requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	var payload jsonResponse
+
+	err = json.Unmarshal(response, &payload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// confirmPasswordResetViaRabbit sets new password by reset token via RabbitMQ
+func (app *Config) confirmPasswordResetViaRabbit(w http.ResponseWriter, rp ResetPasswordPayload) {
+	var requestPayload RequestPayload
+
+	requestPayload.Action = "confirm_password_reset"
+	requestPayload.ResetPassword = rp
+
+	response, err := app.pushToQueue(requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	var payload jsonResponse
+
+	err = json.Unmarshal(response, &payload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
 // deleteUserByEmailViaRabbit deletes user by email via RabbitMQ
 func (app *Config) deleteUserByEmailViaRabbit(w http.ResponseWriter, e EmailPayload) {
 	var requestPayload RequestPayload
@@ -1018,6 +1262,389 @@ func (app *Config) deleteUserByIDViaRabbit(w http.ResponseWriter, i IDPayload) {
 	app.writeJSON(w, http.StatusOK, payload)
 }
 
+// unlockUserViaRabbit removes lockout of user after failed login attempts via RabbitMQ
+func (app *Config) unlockUserViaRabbit(w http.ResponseWriter, e EmailPayload) {
+	var requestPayload RequestPayload
+
+	requestPayload.Action = "unlock_user"
+	requestPayload.Email = e
+
+	response, err := app.pushToQueue(requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	var payload jsonResponse
+
+	err = json.Unmarshal(response, &payload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// enroll2FAViaRabbit generates TOTP secret of user via RabbitMQ
+func (app *Config) enroll2FAViaRabbit(w http.ResponseWriter, tf TwoFactorPayload) {
+	var requestPayload RequestPayload
+
+	requestPayload.Action = "enroll_2fa"
+	requestPayload.TwoFactor = tf
+
+	response, err := app.pushToQueue(requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	var payload jsonResponse
+
+	err = json.Unmarshal(response, &payload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// confirm2FAViaRabbit enables two-factor authentication of user via RabbitMQ
+func (app *Config) confirm2FAViaRabbit(w http.ResponseWriter, tf TwoFactorPayload) {
+	var requestPayload RequestPayload
+
+	requestPayload.Action = "confirm_2fa"
+	requestPayload.TwoFactor = tf
+
+	response, err := app.pushToQueue(requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	var payload jsonResponse
+
+	err = json.Unmarshal(response, &payload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// authenticateUser2FAViaRabbit completes login with second factor via RabbitMQ
+func (app *Config) authenticateUser2FAViaRabbit(w http.ResponseWriter, tf TwoFactorPayload) {
+	var requestPayload RequestPayload
+
+	requestPayload.Action = "authenticate_user_2fa"
+	requestPayload.TwoFactor = tf
+
+	response, err := app.pushToQueue(requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	var payload jsonResponse
+
+	err = json.Unmarshal(response, &payload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// restoreUserViaRabbit restores soft deleted user by ID via RabbitMQ
+func (app *Config) restoreUserViaRabbit(w http.ResponseWriter, i IDPayload) {
+	var requestPayload RequestPayload
+
+	requestPayload.Action = "restore_user"
+	requestPayload.ID = i
+
+	response, err := app.pushToQueue(requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	var payload jsonResponse
+
+	err = json.Unmarshal(response, &payload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// eraseUserViaRabbit permanently deletes user by ID and erases its data via RabbitMQ
+func (app *Config) eraseUserViaRabbit(w http.ResponseWriter, i IDPayload) {
+	var requestPayload RequestPayload
+
+	requestPayload.Action = "erase_user"
+	requestPayload.ID = i
+
+	response, err := app.pushToQueue(requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	var payload jsonResponse
+
+	err = json.Unmarshal(response, &payload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// confirmEmailChangeViaRabbit changes email of user by confirmation token via RabbitMQ
+func (app *Config) confirmEmailChangeViaRabbit(w http.ResponseWriter, t TokenPayload) {
+	var requestPayload RequestPayload
+
+	requestPayload.Action = "confirm_email_change"
+	requestPayload.Token = t
+
+	response, err := app.pushToQueue(requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	var payload jsonResponse
+
+	err = json.Unmarshal(response, &payload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// oidcStartViaRabbit starts login with external identity provider via RabbitMQ
+func (app *Config) oidcStartViaRabbit(w http.ResponseWriter, o OIDCPayload) {
+	var requestPayload RequestPayload
+
+	requestPayload.Action = "oidc_start"
+	requestPayload.OIDC = o
+
+	response, err := app.pushToQueue(requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	var payload jsonResponse
+
+	err = json.Unmarshal(response, &payload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// oidcCallbackViaRabbit finishes login with external identity provider via RabbitMQ
+func (app *Config) oidcCallbackViaRabbit(w http.ResponseWriter, o OIDCPayload) {
+	var requestPayload RequestPayload
+
+	requestPayload.Action = "oidc_callback"
+	requestPayload.OIDC = o
+
+	response, err := app.pushToQueue(requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	var payload jsonResponse
+
+	err = json.Unmarshal(response, &payload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// createAPIKeyViaRabbit creates API key of user via RabbitMQ
+func (app *Config) createAPIKeyViaRabbit(w http.ResponseWriter, k APIKeyPayload) {
+	var requestPayload RequestPayload
+
+	requestPayload.Action = "create_api_key"
+	requestPayload.APIKey = k
+
+	response, err := app.pushToQueue(requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	var payload jsonResponse
+
+	err = json.Unmarshal(response, &payload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusCreated, payload)
+}
+
+// getAPIKeysViaRabbit returns API keys of user via RabbitMQ
+func (app *Config) getAPIKeysViaRabbit(w http.ResponseWriter, k APIKeyPayload) {
+	var requestPayload RequestPayload
+
+	requestPayload.Action = "get_api_keys"
+	requestPayload.APIKey = k
+
+	response, err := app.pushToQueue(requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	var payload jsonResponse
+
+	err = json.Unmarshal(response, &payload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// revokeAPIKeyViaRabbit revokes API key of user via RabbitMQ
+func (app *Config) revokeAPIKeyViaRabbit(w http.ResponseWriter, k APIKeyPayload) {
+	var requestPayload RequestPayload
+
+	requestPayload.Action = "revoke_api_key"
+	requestPayload.APIKey = k
+
+	response, err := app.pushToQueue(requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	var payload jsonResponse
+
+	err = json.Unmarshal(response, &payload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// importUsersViaRabbit imports users from CSV or JSON via RabbitMQ
+func (app *Config) importUsersViaRabbit(w http.ResponseWriter, i ImportUsersPayload) {
+	var requestPayload RequestPayload
+
+	requestPayload.Action = "import_users"
+	requestPayload.ImportUsers = i
+
+	response, err := app.pushToQueue(requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	var payload jsonResponse
+
+	err = json.Unmarshal(response, &payload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// getLogsViaRabbit returns page of logs via RabbitMQ
+func (app *Config) getLogsViaRabbit(w http.ResponseWriter, f LogFilterPayload) {
+	var requestPayload RequestPayload
+
+	requestPayload.Action = "get_logs"
+	requestPayload.LogFilter = f
+
+	response, err := app.pushToQueue(requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	var payload jsonResponse
+
+	err = json.Unmarshal(response, &payload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// getLogStatsViaRabbit returns counts of logs via RabbitMQ
+func (app *Config) getLogStatsViaRabbit(w http.ResponseWriter, s LogStatsPayload) {
+	var requestPayload RequestPayload
+
+	requestPayload.Action = "get_log_stats"
+	requestPayload.LogStats = s
+
+	response, err := app.pushToQueue(requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	var payload jsonResponse
+
+	err = json.Unmarshal(response, &payload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// verifyAuditViaRabbit checks hash chain of audit log via RabbitMQ
+func (app *Config) verifyAuditViaRabbit(w http.ResponseWriter) {
+	var requestPayload RequestPayload
+
+	requestPayload.Action = "verify_audit"
+
+	response, err := app.pushToQueue(requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	var payload jsonResponse
+
+	err = json.Unmarshal(response, &payload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
 // pushToQueue pushes request to queue of RabbitMQ
 func (app *Config) pushToQueue(payload RequestPayload) ([]byte, error) {
 	var response []byte
@@ -1046,16 +1673,54 @@ func (app *Config) pushToQueue(payload RequestPayload) ([]byte, error) {
 		response, err = emitter.PushWithResponse(string(j), payload.Action, "get.user.by.id")
 	case "registration_user":
 		response, err = emitter.PushWithResponse(string(j), payload.Action, "registration.user")
+	case "verify_email":
+		response, err = emitter.PushWithResponse(string(j), payload.Action, "verify.email")
 	case "update_user":
 		response, err = emitter.PushWithResponse(string(j), payload.Action, "update.user")
 	case "change_password":
 		response, err = emitter.PushWithResponse(string(j), payload.Action, "change.password")
+	case "request_password_reset":
+		response, err = emitter.PushWithResponse(string(j), payload.Action, "request.password.reset")
+	case "confirm_password_reset":
+		response, err = emitter.PushWithResponse(string(j), payload.Action, "confirm.password.reset")
 	case "delete_user_by_email":
 		response, err = emitter.PushWithResponse(string(j), payload.Action, "delete.user.by.email")
 	case "delete_user_by_id":
 		response, err = emitter.PushWithResponse(string(j), payload.Action, "delete.user.by.id")
 	case "authenticate_user_session":
 		response, err = emitter.PushWithResponse(string(j), payload.Action, "authenticate.user.session")
+	case "unlock_user":
+		response, err = emitter.PushWithResponse(string(j), payload.Action, "unlock.user")
+	case "enroll_2fa":
+		response, err = emitter.PushWithResponse(string(j), payload.Action, "enroll.2fa")
+	case "confirm_2fa":
+		response, err = emitter.PushWithResponse(string(j), payload.Action, "confirm.2fa")
+	case "authenticate_user_2fa":
+		response, err = emitter.PushWithResponse(string(j), payload.Action, "authenticate.user.2fa")
+	case "restore_user":
+		response, err = emitter.PushWithResponse(string(j), payload.Action, "restore.user")
+	case "erase_user":
+		response, err = emitter.PushWithResponse(string(j), payload.Action, "erase.user")
+	case "confirm_email_change":
+		response, err = emitter.PushWithResponse(string(j), payload.Action, "confirm.email.change")
+	case "oidc_start":
+		response, err = emitter.PushWithResponse(string(j), payload.Action, "oidc.start")
+	case "oidc_callback":
+		response, err = emitter.PushWithResponse(string(j), payload.Action, "oidc.callback")
+	case "create_api_key":
+		response, err = emitter.PushWithResponse(string(j), payload.Action, "create.api.key")
+	case "get_api_keys":
+		response, err = emitter.PushWithResponse(string(j), payload.Action, "get.api.keys")
+	case "revoke_api_key":
+		response, err = emitter.PushWithResponse(string(j), payload.Action, "revoke.api.key")
+	case "import_users":
+		response, err = emitter.PushWithResponse(string(j), payload.Action, "import.users")
+	case "get_logs":
+		response, err = emitter.PushWithResponse(string(j), payload.Action, "get.logs")
+	case "get_log_stats":
+		response, err = emitter.PushWithResponse(string(j), payload.Action, "get.log.stats")
+	case "verify_audit":
+		response, err = emitter.PushWithResponse(string(j), payload.Action, "verify.audit")
 	default:
 		log.Printf("invalid name of channel RabbitMQ %s", payload.Action)
 	}
@@ -1067,6 +1732,150 @@ func (app *Config) pushToQueue(payload RequestPayload) ([]byte, error) {
 	return response, err
 }
 
+// ExportUsers streams users as CSV or JSON from authentication service to client
+func (app *Config) ExportUsers(w http.ResponseWriter, r *http.Request) {
+	status, err := app.authorize(r, "export_users")
+	if err != nil {
+		app.errorJSON(w, err, status)
+		return
+	}
+
+	query := url.Values{}
+	query.Set("format", r.URL.Query().Get("format"))
+
+	app.proxyStream(w, r, "http://authentication-service/export_users?"+query.Encode())
+}
+
+// TailToken returns short-lived single-use token for tail of logs. EventSource can`t set headers,
+// so token is sent in query of /logs/tail
+func (app *Config) TailToken(w http.ResponseWriter, r *http.Request) {
+	status, err := app.authorize(r, "get_logs")
+	if err != nil {
+		app.errorJSON(w, err, status)
+		return
+	}
+
+	p := r.Context().Value(principalKey).(*principal)
+
+	jsonData, _ := json.Marshal(IDPayload{ID: p.ID})
+
+	request, err := http.NewRequest("POST", "http://authentication-service/create_stream_token", bytes.NewBuffer(jsonData))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	client := &http.Client{}
+	response, err := client.Do(request)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusUnauthorized {
+		app.errorJSON(w, errors.New("invalid credentials"), http.StatusUnauthorized)
+		return
+	} else if response.StatusCode != http.StatusCreated {
+		app.errorJSON(w, errors.New("error calling auth service"))
+		return
+	}
+
+	var jsonFromService jsonResponse
+
+	err = json.NewDecoder(response.Body).Decode(&jsonFromService)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	var payload jsonResponse
+	payload.Error = false
+	payload.Message = "tail token created"
+	payload.Data = jsonFromService.Data
+
+	app.writeJSON(w, http.StatusCreated, payload)
+}
+
+// TailLogs streams new log entries of logger service as server-sent events. Query parameters
+// are filters of get_logs and stream token
+func (app *Config) TailLogs(w http.ResponseWriter, r *http.Request) {
+	status, err := app.authorize(r, "get_logs")
+	if err != nil {
+		app.errorJSON(w, err, status)
+		return
+	}
+
+	// stream token isn`t sent to logger service
+	query := r.URL.Query()
+	query.Del("token")
+
+	app.proxyStream(w, r, "http://logger-service/logs/tail?"+query.Encode())
+}
+
+// ExportLogs streams log entries of logger service as NDJSON or CSV. Query parameters are format
+// and filters of get_logs
+func (app *Config) ExportLogs(w http.ResponseWriter, r *http.Request) {
+	status, err := app.authorize(r, "export_logs")
+	if err != nil {
+		app.errorJSON(w, err, status)
+		return
+	}
+
+	app.proxyStream(w, r, "http://logger-service/logs/export?"+r.URL.RawQuery)
+}
+
+// proxyStream sends GET request to service and copies response to client while it is read.
+// Request is canceled when client goes away
+func (app *Config) proxyStream(w http.ResponseWriter, r *http.Request, serviceURL string) {
+	request, err := http.NewRequestWithContext(r.Context(), "GET", serviceURL, nil)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	client := &http.Client{}
+
+	response, err := client.Do(request)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	defer response.Body.Close()
+
+	for _, header := range []string{"Content-Type", "Content-Disposition", "Cache-Control"} {
+		if value := response.Header.Get(header); value != "" {
+			w.Header().Set(header, value)
+		}
+	}
+	w.WriteHeader(response.StatusCode)
+
+	// flush every chunk, so client gets data while it is read
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := response.Body.Read(buf)
+		if n > 0 {
+			_, writeErr := w.Write(buf[:n])
+			if writeErr != nil {
+				log.Println("error streaming response:", writeErr)
+				return
+			}
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+		}
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			if r.Context().Err() == nil {
+				log.Println("error streaming response:", err)
+			}
+			return
+		}
+	}
+}
+
 // logItemViaRpc logs some data via RPC
 func (app *Config) logItemViaRpc(w http.ResponseWriter, l LogPayload) {
 	client, err := rpc.Dial("tcp", "logger-service:5001")
@@ -1076,8 +1885,17 @@ func (app *Config) logItemViaRpc(w http.ResponseWriter, l LogPayload) {
 	}
 
 	rpcPayload := RPCPayload{
-		Name: l.Name,
-		Data: l.Name,
+		Name:    l.Name,
+		Data:    l.Data,
+		Level:   l.Level,
+		Service: l.Service,
+		TraceID: l.TraceID,
+		UserID:  l.UserID,
+		Fields:  l.Fields,
+	}
+
+	if l.Timestamp != nil {
+		rpcPayload.Timestamp = *l.Timestamp
 	}
 
 	var result string
@@ -1105,6 +1923,12 @@ func (app *Config) LogViaGRPC(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	status, err := app.authorize(r, "log")
+	if err != nil {
+		app.errorJSON(w, err, status)
+		return
+	}
+
 	conn, err := grpc.Dial("logger-service:50001", grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithBlock())
 	if err != nil {
 		app.errorJSON(w, err)
@@ -1116,12 +1940,28 @@ func (app *Config) LogViaGRPC(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
 
-	_, err = c.WriteLog(ctx, &logs.LogRequest{
-		LogEntry: &logs.Log{
-			Name: requestPayload.Log.Name,
-			Data: requestPayload.Log.Data,
-		},
-	})
+	logEntry := &logs.Log{
+		Name:    requestPayload.Log.Name,
+		Data:    requestPayload.Log.Data,
+		Level:   requestPayload.Log.Level,
+		Service: requestPayload.Log.Service,
+		TraceId: requestPayload.Log.TraceID,
+		UserId:  requestPayload.Log.UserID,
+	}
+
+	if requestPayload.Log.Timestamp != nil {
+		logEntry.Timestamp = timestamppb.New(*requestPayload.Log.Timestamp)
+	}
+
+	// values of gRPC fields are strings
+	if len(requestPayload.Log.Fields) > 0 {
+		logEntry.Fields = make(map[string]string, len(requestPayload.Log.Fields))
+		for key, value := range requestPayload.Log.Fields {
+			logEntry.Fields[key] = fmt.Sprint(value)
+		}
+	}
+
+	_, err = c.WriteLog(ctx, &logs.LogRequest{LogEntry: logEntry})
 	if err != nil {
 		app.errorJSON(w, err)
 		return
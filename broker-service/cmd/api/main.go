@@ -5,8 +5,10 @@ import (
 	amqp "github.com/rabbitmq/amqp091-go"
 	"log"
 	"math"
+	"net"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 )
 
@@ -14,7 +16,9 @@ import (
 const webPort = "80"
 
 type Config struct {
-	Rabbit *amqp.Connection
+	Rabbit         *amqp.Connection
+	RequireAuth    bool
+	TrustedProxies []*net.IPNet
 }
 
 func main() {
@@ -26,8 +30,20 @@ func main() {
 	}
 	defer rabbitConn.Close()
 
+	// actions with scope are denied for clients without bearer token or api key if it is "true",
+	// otherwise anonymous clients may do actions which are not privileged
+	requireAuth := os.Getenv("REQUIRE_AUTH") == "true"
+
+	// X-Forwarded-For and X-Real-IP are trusted only from these networks, e.g. network of caddy
+	trustedProxies, err := parseCIDRs(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		log.Panic(err)
+	}
+
 	app := Config{
-		Rabbit: rabbitConn,
+		Rabbit:         rabbitConn,
+		RequireAuth:    requireAuth,
+		TrustedProxies: trustedProxies,
 	}
 
 	log.Printf("Starting broker service on port %s\n", webPort)
@@ -75,3 +91,23 @@ func connect() (*amqp.Connection, error) {
 
 	return connection, nil
 }
+
+// parseCIDRs parses comma separated networks
+func parseCIDRs(value string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+
+	for _, cidr := range strings.Split(value, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TRUSTED_PROXIES network %q: %v", cidr, err)
+		}
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
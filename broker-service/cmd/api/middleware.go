@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type contextKey string
+
+const principalKey contextKey = "principal"
+
+// actionScopes maps actions to scope which API key must have to do them.
+// Actions which are not listed here don`t need any scope
+var actionScopes = map[string]string{
+	"get_all_users":        "users:read",
+	"get_user_by_email":    "users:read",
+	"get_user_by_id":       "users:read",
+	"update_user":          "users:write",
+	"change_password":      "users:write",
+	"delete_user_by_email": "users:admin",
+	"delete_user_by_id":    "users:admin",
+	"restore_user":         "users:admin",
+	"erase_user":           "users:admin",
+	"unlock_user":          "users:admin",
+	"import_users":         "users:admin",
+	"export_users":         "users:admin",
+	"log":                  "logs:write",
+	"get_logs":             "logs:read",
+	"export_logs":          "logs:read",
+	"get_log_stats":        "logs:read",
+	"verify_audit":         "users:admin",
+	"mail":                 "mail:send",
+}
+
+// privilegedScopes always need credentials, even if RequireAuth is off
+var privilegedScopes = map[string]bool{
+	"users:admin": true,
+	"logs:read":   true,
+}
+
+var (
+	errUnauthorized = errors.New("authentication required")
+	errForbidden    = errors.New("credentials don`t have scope for this action")
+	errNotOwner     = errors.New("only admin can do this action on other users")
+)
+
+// principal stores client authenticated by bearer token or API key. Principal who is not admin
+// may do users actions only on own account
+type principal struct {
+	ID       int      `json:"id"`
+	UserID   string   `json:"user_id"`
+	Email    string   `json:"email"`
+	Admin    bool     `json:"admin"`
+	Scopes   []string `json:"scopes"`
+	APIKeyID int      `json:"api_key_id,omitempty"`
+}
+
+// realIP sets RemoteAddr to IP of client. Forwarding headers are trusted only if request came from
+// trusted proxy, otherwise client could put any IP into them and bypass limits by IP
+func (app *Config) realIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ip := net.ParseIP(clientIP(r)); ip != nil && app.trusted(ip) {
+			if forwarded := app.forwardedIP(r); forwarded != "" {
+				r.RemoteAddr = forwarded
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// forwardedIP returns the last address of X-Forwarded-For which isn`t trusted proxy, addresses
+// before it are written by client. X-Real-IP is used if there is no X-Forwarded-For
+func (app *Config) forwardedIP(r *http.Request) string {
+	if header := r.Header.Get("X-Forwarded-For"); header != "" {
+		hops := strings.Split(header, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(hops[i]))
+			if ip == nil {
+				return ""
+			}
+			if !app.trusted(ip) || i == 0 {
+				return ip.String()
+			}
+		}
+	}
+
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+
+	return ""
+}
+
+func (app *Config) trusted(ip net.IP) bool {
+	for _, network := range app.TrustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// streamPaths are streams which are opened by EventSource, it can`t set headers, so short-lived
+// stream token is accepted in query
+var streamPaths = map[string]bool{
+	"/logs/tail": true,
+}
+
+// credentials are sent to authentication service to get principal
+type credentials struct {
+	APIKey       string `json:"api_key,omitempty"`
+	SessionToken string `json:"session_token,omitempty"`
+	StreamToken  string `json:"stream_token,omitempty"`
+}
+
+// authenticate checks X-API-Key header, bearer token of Authorization header or stream token of
+// query and puts principal into context of request. Requests without credentials are passed as anonymous
+func (app *Config) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		creds := credentials{APIKey: r.Header.Get("X-API-Key")}
+
+		if header := r.Header.Get("Authorization"); header != "" {
+			token, ok := strings.CutPrefix(header, "Bearer ")
+			if !ok || token == "" {
+				app.errorJSON(w, errors.New("authorization header must be bearer token"), http.StatusUnauthorized)
+				return
+			}
+			creds.SessionToken = token
+		}
+
+		if streamPaths[r.URL.Path] && creds.APIKey == "" && creds.SessionToken == "" {
+			creds.StreamToken = r.URL.Query().Get("token")
+		}
+
+		if creds == (credentials{}) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		p, err := verifyCredentials(creds)
+		if err != nil {
+			app.errorJSON(w, err, http.StatusUnauthorized)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), principalKey, p)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// authorize checks that client of request may do action. If RequireAuth is off, anonymous
+// clients may do actions which are not privileged, but credentials are always checked for scope
+func (app *Config) authorize(r *http.Request, action string) (int, error) {
+	scope, ok := actionScopes[action]
+	if !ok {
+		return http.StatusOK, nil
+	}
+
+	p, ok := r.Context().Value(principalKey).(*principal)
+	if !ok {
+		if app.RequireAuth || privilegedScopes[scope] {
+			return http.StatusUnauthorized, errUnauthorized
+		}
+		return http.StatusOK, nil
+	}
+
+	for _, s := range p.Scopes {
+		if s == scope {
+			return http.StatusOK, nil
+		}
+	}
+
+	return http.StatusForbidden, fmt.Errorf("%w: %s", errForbidden, scope)
+}
+
+// authorizeOwner checks that principal who is not admin does users actions only on own account
+func authorizeOwner(r *http.Request, requestPayload RequestPayload) (int, error) {
+	p, ok := r.Context().Value(principalKey).(*principal)
+	if !ok || p.Admin {
+		return http.StatusOK, nil
+	}
+
+	var own bool
+
+	switch requestPayload.Action {
+	case "get_all_users":
+		// list has accounts of other users
+		own = false
+	case "get_user_by_email":
+		own = strings.EqualFold(requestPayload.Email.Email, p.Email)
+	case "get_user_by_id":
+		own = requestPayload.ID.ID == p.ID
+	case "update_user":
+		own = strings.EqualFold(requestPayload.UpdateUser.Email, p.Email)
+	case "change_password":
+		own = strings.EqualFold(requestPayload.ChangePassword.Email, p.Email)
+	default:
+		return http.StatusOK, nil
+	}
+
+	if !own {
+		return http.StatusForbidden, errNotOwner
+	}
+
+	return http.StatusOK, nil
+}
+
+// verifyCredentials returns principal of credentials from authentication service
+func verifyCredentials(creds credentials) (*principal, error) {
+	jsonData, _ := json.Marshal(creds)
+
+	request, err := http.NewRequest("POST", "http://authentication-service/verify_credentials", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Timeout: 5 * time.Second}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	var payload struct {
+		Error   bool       `json:"error"`
+		Message string     `json:"message"`
+		Data    *principal `json:"data"`
+	}
+
+	err = json.NewDecoder(response.Body).Decode(&payload)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode != http.StatusOK || payload.Error || payload.Data == nil {
+		return nil, errors.New(payload.Message)
+	}
+
+	return payload.Data, nil
+}
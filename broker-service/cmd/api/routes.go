@@ -15,7 +15,7 @@ func (app *Config) routes() http.Handler {
 	mux.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"https://*", "http://*"},
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "X-API-Key"},
 		ExposedHeaders:   []string{"Link"},
 		AllowCredentials: true,
 		MaxAge:           300,
@@ -23,11 +23,23 @@ func (app *Config) routes() http.Handler {
 
 	mux.Use(middleware.Heartbeat("/ping"))
 
+	// get real ip of client behind caddy
+	mux.Use(app.realIP)
+
+	// accept bearer token or api key
+	mux.Use(app.authenticate)
+
 	mux.Get("/", app.Broker)
 
 	mux.Post("/log-grpc", app.LogViaGRPC)
 
 	mux.Post("/handle", app.HandleSubmission)
 
+	// exports and tail are streamed, so they can`t be sent via RabbitMQ
+	mux.Get("/export_users", app.ExportUsers)
+	mux.Get("/logs/tail", app.TailLogs)
+	mux.Post("/logs/tail_token", app.TailToken)
+	mux.Get("/logs/export", app.ExportLogs)
+
 	return mux
 }
@@ -8,7 +8,11 @@ import (
 func declareExchange(name string, ch *amqp.Channel) error {
 	switch name {
 	case "log", "mail", "authenticate_user", "get_user_by_email", "get_user_by_id", "get_all_users", "registration_user",
-		"update_user", "change_password", "delete_user_by_email", "delete_user_by_id", "authenticate_user_session":
+		"update_user", "change_password", "delete_user_by_email", "delete_user_by_id", "authenticate_user_session",
+		"verify_email", "request_password_reset", "confirm_password_reset", "unlock_user", "enroll_2fa",
+		"confirm_2fa", "authenticate_user_2fa", "restore_user", "erase_user", "confirm_email_change", "oidc_start",
+		"oidc_callback", "create_api_key", "get_api_keys", "revoke_api_key", "import_users", "get_logs",
+		"get_log_stats", "verify_audit":
 		return ch.ExchangeDeclare(
 			name,
 			"topic",
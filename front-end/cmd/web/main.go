@@ -14,6 +14,19 @@ func main() {
 		render(w, "test.page.html")
 	})
 
+	// pages of links from emails
+	http.HandleFunc("/verify_email", func(w http.ResponseWriter, r *http.Request) {
+		render(w, "verify_email.page.html")
+	})
+
+	http.HandleFunc("/reset_password", func(w http.ResponseWriter, r *http.Request) {
+		render(w, "reset_password.page.html")
+	})
+
+	http.HandleFunc("/confirm_email_change", func(w http.ResponseWriter, r *http.Request) {
+		render(w, "confirm_email_change.page.html")
+	})
+
 	//in swarm 8081
 	fmt.Println("Starting front end service on port 1234")
 	err := http.ListenAndServe(":1234", nil)
@@ -9,6 +9,9 @@ import (
 	amqp "github.com/rabbitmq/amqp091-go"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"time"
 )
 
 type Consumer struct {
@@ -29,12 +32,21 @@ type Payload struct {
 	Auth           AuthUserPayload       `json:"auth,omitempty"`
 	Session        SessionTokenPayload   `json:"session,omitempty"`
 	Reg            RegUserPayload        `json:"reg,omitempty"`
+	Token          TokenPayload          `json:"token,omitempty"`
 	UpdateUser     UpdateUserPayload     `json:"update_user,omitempty"`
 	ChangePassword ChangePasswordPayload `json:"change_password,omitempty"`
+	ResetPassword  ResetPasswordPayload  `json:"reset_password,omitempty"`
 	Email          EmailPayload          `json:"email,omitempty"`
 	ID             IDPayload             `json:"id,omitempty"`
 	Log            LogPayload            `json:"log,omitempty"`
 	Mail           MailPayload           `json:"mail,omitempty"`
+	TwoFactor      TwoFactorPayload      `json:"two_factor,omitempty"`
+	Filter         UserFilterPayload     `json:"filter,omitempty"`
+	OIDC           OIDCPayload           `json:"oidc,omitempty"`
+	APIKey         APIKeyPayload         `json:"api_key,omitempty"`
+	ImportUsers    ImportUsersPayload    `json:"import_users,omitempty"`
+	LogFilter      LogFilterPayload      `json:"log_filter,omitempty"`
+	LogStats       LogStatsPayload       `json:"log_stats,omitempty"`
 }
 
 // MailPayload stores data to send mail to user
@@ -49,6 +61,7 @@ type MailPayload struct {
 type AuthUserPayload struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	IP       string `json:"ip,omitempty"`
 }
 
 // RegUserPayload stores data to registration user
@@ -57,16 +70,21 @@ type RegUserPayload struct {
 	FirstName string `json:"first_name,omitempty"`
 	LastName  string `json:"last_name,omitempty"`
 	Password  string `json:"password"`
-	Active    int    `json:"active"`
+}
+
+// TokenPayload stores single-use token sent to user`s email
+type TokenPayload struct {
+	Token string `json:"token"`
 }
 
 // UpdateUserPayload stores data to update user
 type UpdateUserPayload struct {
-	Email       string `json:"email"`
-	EmailChange string `json:"email_change"`
-	FirstName   string `json:"first_name,omitempty,omitempty"`
-	LastName    string `json:"last_name,omitempty,omitempty"`
-	Active      int    `json:"active,omitempty"`
+	Email       string  `json:"email"`
+	EmailChange *string `json:"email_change,omitempty"`
+	FirstName   *string `json:"first_name,omitempty"`
+	LastName    *string `json:"last_name,omitempty"`
+	Active      *int    `json:"active,omitempty"`
+	Version     *int    `json:"version,omitempty"`
 }
 
 // ChangePasswordPayload stores data to change password
@@ -76,6 +94,12 @@ type ChangePasswordPayload struct {
 	NewPassword string `json:"new_password"`
 }
 
+// ResetPasswordPayload stores data to reset forgotten password
+type ResetPasswordPayload struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
 // EmailPayload stores data of email
 type EmailPayload struct {
 	Email string `json:"email"`
@@ -86,10 +110,16 @@ type IDPayload struct {
 	ID int `json:"id"`
 }
 
-// LogPayload stores log data
+// LogPayload stores log data, all fields except name and data are optional
 type LogPayload struct {
-	Name string `json:"name"`
-	Data string `json:"data"`
+	Name      string         `json:"name"`
+	Data      string         `json:"data"`
+	Level     string         `json:"level,omitempty"`
+	Service   string         `json:"service,omitempty"`
+	TraceID   string         `json:"trace_id,omitempty"`
+	UserID    string         `json:"user_id,omitempty"`
+	Fields    map[string]any `json:"fields,omitempty"`
+	Timestamp *time.Time     `json:"timestamp,omitempty"`
 }
 
 // SessionTokenPayload stores token of user`s session
@@ -97,6 +127,78 @@ type SessionTokenPayload struct {
 	SessionToken string `json:"session_token"`
 }
 
+// TwoFactorPayload stores data of two-factor authentication
+type TwoFactorPayload struct {
+	SessionToken string `json:"session_token,omitempty"`
+	Challenge    string `json:"challenge,omitempty"`
+	Code         string `json:"code,omitempty"`
+}
+
+// UserFilterPayload stores pagination, filters and sort order of users list
+type UserFilterPayload struct {
+	Page        int    `json:"page,omitempty"`
+	PageSize    int    `json:"page_size,omitempty"`
+	Active      *int   `json:"active,omitempty"`
+	CreatedFrom string `json:"created_from,omitempty"`
+	CreatedTo   string `json:"created_to,omitempty"`
+	Search      string `json:"search,omitempty"`
+	Sort        string `json:"sort,omitempty"`
+	Deleted     bool   `json:"deleted,omitempty"`
+}
+
+// OIDCPayload stores data of login with external identity provider
+type OIDCPayload struct {
+	Provider string `json:"provider,omitempty"`
+	State    string `json:"state,omitempty"`
+	Code     string `json:"code,omitempty"`
+}
+
+// APIKeyPayload stores data to manage API keys of user
+type APIKeyPayload struct {
+	SessionToken string   `json:"session_token"`
+	Name         string   `json:"name,omitempty"`
+	Scopes       []string `json:"scopes,omitempty"`
+	ID           int      `json:"id,omitempty"`
+}
+
+// ImportUsersPayload stores CSV or JSON with users to import
+type ImportUsersPayload struct {
+	Format string `json:"format"`
+	Data   string `json:"data"`
+	DryRun bool   `json:"dry_run"`
+}
+
+// LogFilterPayload stores filters, sort order and cursor of logs list
+type LogFilterPayload struct {
+	Name    string `json:"name,omitempty"`
+	Level   string `json:"level,omitempty"`
+	Service string `json:"service,omitempty"`
+	UserID  string `json:"user_id,omitempty"`
+	TraceID string `json:"trace_id,omitempty"`
+	From    string `json:"from,omitempty"`
+	To      string `json:"to,omitempty"`
+	Search  string `json:"search,omitempty"`
+	Sort    string `json:"sort,omitempty"`
+	Limit   int    `json:"limit,omitempty"`
+	Cursor  string `json:"cursor,omitempty"`
+}
+
+// LogStatsPayload stores grouping, time interval and filters of log statistics
+type LogStatsPayload struct {
+	GroupBy  []string `json:"group_by,omitempty"`
+	Interval string   `json:"interval,omitempty"`
+	Timezone string   `json:"timezone,omitempty"`
+	Top      int      `json:"top,omitempty"`
+	Name     string   `json:"name,omitempty"`
+	Level    string   `json:"level,omitempty"`
+	Service  string   `json:"service,omitempty"`
+	UserID   string   `json:"user_id,omitempty"`
+	TraceID  string   `json:"trace_id,omitempty"`
+	From     string   `json:"from,omitempty"`
+	To       string   `json:"to,omitempty"`
+	Search   string   `json:"search,omitempty"`
+}
+
 func NewConsumer(conn *amqp.Connection) (Consumer, error) {
 	consumer := Consumer{
 		conn: conn,
@@ -167,6 +269,63 @@ func (consumer *Consumer) Listen() error {
 	if err = ch.QueueBind(q.Name, "authenticate.user.session", "authenticate_user_session", false, nil); err != nil {
 		return err
 	}
+	if err = ch.QueueBind(q.Name, "verify.email", "verify_email", false, nil); err != nil {
+		return err
+	}
+	if err = ch.QueueBind(q.Name, "request.password.reset", "request_password_reset", false, nil); err != nil {
+		return err
+	}
+	if err = ch.QueueBind(q.Name, "confirm.password.reset", "confirm_password_reset", false, nil); err != nil {
+		return err
+	}
+	if err = ch.QueueBind(q.Name, "unlock.user", "unlock_user", false, nil); err != nil {
+		return err
+	}
+	if err = ch.QueueBind(q.Name, "enroll.2fa", "enroll_2fa", false, nil); err != nil {
+		return err
+	}
+	if err = ch.QueueBind(q.Name, "confirm.2fa", "confirm_2fa", false, nil); err != nil {
+		return err
+	}
+	if err = ch.QueueBind(q.Name, "authenticate.user.2fa", "authenticate_user_2fa", false, nil); err != nil {
+		return err
+	}
+	if err = ch.QueueBind(q.Name, "restore.user", "restore_user", false, nil); err != nil {
+		return err
+	}
+	if err = ch.QueueBind(q.Name, "erase.user", "erase_user", false, nil); err != nil {
+		return err
+	}
+	if err = ch.QueueBind(q.Name, "confirm.email.change", "confirm_email_change", false, nil); err != nil {
+		return err
+	}
+	if err = ch.QueueBind(q.Name, "oidc.start", "oidc_start", false, nil); err != nil {
+		return err
+	}
+	if err = ch.QueueBind(q.Name, "oidc.callback", "oidc_callback", false, nil); err != nil {
+		return err
+	}
+	if err = ch.QueueBind(q.Name, "create.api.key", "create_api_key", false, nil); err != nil {
+		return err
+	}
+	if err = ch.QueueBind(q.Name, "get.api.keys", "get_api_keys", false, nil); err != nil {
+		return err
+	}
+	if err = ch.QueueBind(q.Name, "revoke.api.key", "revoke_api_key", false, nil); err != nil {
+		return err
+	}
+	if err = ch.QueueBind(q.Name, "import.users", "import_users", false, nil); err != nil {
+		return err
+	}
+	if err = ch.QueueBind(q.Name, "get.logs", "get_logs", false, nil); err != nil {
+		return err
+	}
+	if err = ch.QueueBind(q.Name, "get.log.stats", "get_log_stats", false, nil); err != nil {
+		return err
+	}
+	if err = ch.QueueBind(q.Name, "verify.audit", "verify_audit", false, nil); err != nil {
+		return err
+	}
 
 	messages, err := ch.Consume(q.Name, "", true, false, false, false, nil)
 	if err != nil {
@@ -237,7 +396,7 @@ func handlePayload(payload Payload) jsonResponse {
 		response = resp
 
 	case "get_all_users":
-		resp, err := getAllUsers()
+		resp, err := getAllUsers(payload)
 		if err != nil {
 			log.Println(err)
 		}
@@ -278,6 +437,20 @@ func handlePayload(payload Payload) jsonResponse {
 		}
 		response = resp
 
+	case "request_password_reset":
+		resp, err := requestPasswordReset(payload)
+		if err != nil {
+			log.Println(err)
+		}
+		response = resp
+
+	case "confirm_password_reset":
+		resp, err := confirmPasswordReset(payload)
+		if err != nil {
+			log.Println(err)
+		}
+		response = resp
+
 	case "delete_user_by_email":
 		resp, err := deleteUserByEmail(payload)
 		if err != nil {
@@ -299,6 +472,125 @@ func handlePayload(payload Payload) jsonResponse {
 		}
 		response = resp
 
+	case "verify_email":
+		resp, err := verifyEmail(payload)
+		if err != nil {
+			log.Println(err)
+		}
+		response = resp
+
+	case "unlock_user":
+		resp, err := unlockUser(payload)
+		if err != nil {
+			log.Println(err)
+		}
+		response = resp
+
+	case "enroll_2fa":
+		resp, err := enroll2FA(payload)
+		if err != nil {
+			log.Println(err)
+		}
+		response = resp
+
+	case "confirm_2fa":
+		resp, err := confirm2FA(payload)
+		if err != nil {
+			log.Println(err)
+		}
+		response = resp
+
+	case "authenticate_user_2fa":
+		resp, err := authenticateUser2FA(payload)
+		if err != nil {
+			log.Println(err)
+		}
+		response = resp
+
+	case "restore_user":
+		resp, err := restoreUser(payload)
+		if err != nil {
+			log.Println(err)
+		}
+		response = resp
+
+	case "erase_user":
+		resp, err := eraseUser(payload)
+		if err != nil {
+			log.Println(err)
+		}
+		response = resp
+
+	case "confirm_email_change":
+		resp, err := confirmEmailChange(payload)
+		if err != nil {
+			log.Println(err)
+		}
+		response = resp
+
+	case "oidc_start":
+		resp, err := oidcStart(payload)
+		if err != nil {
+			log.Println(err)
+		}
+		response = resp
+
+	case "oidc_callback":
+		resp, err := oidcCallback(payload)
+		if err != nil {
+			log.Println(err)
+		}
+		response = resp
+
+	case "create_api_key":
+		resp, err := createAPIKey(payload)
+		if err != nil {
+			log.Println(err)
+		}
+		response = resp
+
+	case "get_api_keys":
+		resp, err := getAPIKeys(payload)
+		if err != nil {
+			log.Println(err)
+		}
+		response = resp
+
+	case "revoke_api_key":
+		resp, err := revokeAPIKey(payload)
+		if err != nil {
+			log.Println(err)
+		}
+		response = resp
+
+	case "import_users":
+		resp, err := importUsers(payload)
+		if err != nil {
+			log.Println(err)
+		}
+		response = resp
+
+	case "get_logs":
+		resp, err := getLogs(payload)
+		if err != nil {
+			log.Println(err)
+		}
+		response = resp
+
+	case "get_log_stats":
+		resp, err := getLogStats(payload)
+		if err != nil {
+			log.Println(err)
+		}
+		response = resp
+
+	case "verify_audit":
+		resp, err := verifyAudit()
+		if err != nil {
+			log.Println(err)
+		}
+		response = resp
+
 	default:
 		errString := fmt.Sprintf("invalid name of function %s, RabbitMQ", payload.Action)
 		log.Println(errString)
@@ -369,9 +661,16 @@ func authUserSession(entry Payload) (jsonResponse, error) {
 	return handleSync(request, http.StatusOK)
 }
 
-// getAllUsers returns all users via RabbitMQ
-func getAllUsers() (jsonResponse, error) {
-	request, err := http.NewRequest("GET", "http://authentication-service/get_all", nil)
+// getAllUsers returns page of users via RabbitMQ
+func getAllUsers(entry Payload) (jsonResponse, error) {
+	// create some json we'll send to the auth microservice
+	jsonData, err := json.MarshalIndent(entry.Filter, "", "\t")
+	if err != nil {
+		return jsonResponse{Error: true, Message: fmt.Sprintf("error %v", err)}, err
+	}
+
+	// call the service
+	request, err := http.NewRequest("POST", "http://authentication-service/get_all", bytes.NewBuffer(jsonData))
 	if err != nil {
 		return jsonResponse{Error: true, Message: fmt.Sprintf("error %v", err)}, err
 	}
@@ -430,6 +729,40 @@ func registrationUser(entry Payload) (jsonResponse, error) {
 	return handleSync(request, http.StatusCreated)
 }
 
+// unlockUser removes lockout of user after failed login attempts via RabbitMQ
+func unlockUser(entry Payload) (jsonResponse, error) {
+	// create some json we'll send to the auth microservice
+	jsonData, err := json.MarshalIndent(entry.Email, "", "\t")
+	if err != nil {
+		return jsonResponse{Error: true, Message: fmt.Sprintf("error %v", err)}, err
+	}
+
+	// call the service
+	request, err := http.NewRequest("POST", "http://authentication-service/unlock_user", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return jsonResponse{Error: true, Message: fmt.Sprintf("error %v", err)}, err
+	}
+
+	return handleSync(request, http.StatusOK)
+}
+
+// verifyEmail activates user by verification token via RabbitMQ
+func verifyEmail(entry Payload) (jsonResponse, error) {
+	// create some json we'll send to the auth microservice
+	jsonData, err := json.MarshalIndent(entry.Token, "", "\t")
+	if err != nil {
+		return jsonResponse{Error: true, Message: fmt.Sprintf("error %v", err)}, err
+	}
+
+	// call the service
+	request, err := http.NewRequest("POST", "http://authentication-service/verify_email", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return jsonResponse{Error: true, Message: fmt.Sprintf("error %v", err)}, err
+	}
+
+	return handleSync(request, http.StatusOK)
+}
+
 // updateUser updates some user`s fields via RabbitMQ
 func updateUser(entry Payload) (jsonResponse, error) {
 	// create some json we'll send to the auth microservice
@@ -439,7 +772,7 @@ func updateUser(entry Payload) (jsonResponse, error) {
 	}
 
 	// call the service
-	request, err := http.NewRequest("PUT", "http://authentication-service/update", bytes.NewBuffer(jsonData))
+	request, err := http.NewRequest("PATCH", "http://authentication-service/update", bytes.NewBuffer(jsonData))
 	if err != nil {
 		return jsonResponse{Error: true, Message: fmt.Sprintf("error %v", err)}, err
 	}
@@ -464,6 +797,40 @@ func changePassword(entry Payload) (jsonResponse, error) {
 	return handleSync(request, http.StatusOK)
 }
 
+// requestPasswordReset sends email with link to reset password via RabbitMQ
+func requestPasswordReset(entry Payload) (jsonResponse, error) {
+	// create some json we'll send to the auth microservice
+	jsonData, err := json.MarshalIndent(entry.Email, "", "\t")
+	if err != nil {
+		return jsonResponse{Error: true, Message: fmt.Sprintf("error %v", err)}, err
+	}
+
+	// call the service
+	request, err := http.NewRequest("POST", "http://authentication-service/request_password_reset", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return jsonResponse{Error: true, Message: fmt.Sprintf("error %v", err)}, err
+	}
+
+	return handleSync(request, http.StatusOK)
+}
+
+// confirmPasswordReset sets new password by reset token via RabbitMQ
+func confirmPasswordReset(entry Payload) (jsonResponse, error) {
+	// create some json we'll send to the auth microservice
+	jsonData, err := json.MarshalIndent(entry.ResetPassword, "", "\t")
+	if err != nil {
+		return jsonResponse{Error: true, Message: fmt.Sprintf("error %v", err)}, err
+	}
+
+	// call the service
+	request, err := http.NewRequest("PUT", "http://authentication-service/confirm_password_reset", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return jsonResponse{Error: true, Message: fmt.Sprintf("error %v", err)}, err
+	}
+
+	return handleSync(request, http.StatusOK)
+}
+
 // deleteUserByEmail delete user by email via RabbitMQ
 func deleteUserByEmail(entry Payload) (jsonResponse, error) {
 	// create some json we'll send to the auth microservice
@@ -498,6 +865,271 @@ func deleteUserByID(entry Payload) (jsonResponse, error) {
 	return handleSync(request, http.StatusOK)
 }
 
+// enroll2FA generates TOTP secret of user via RabbitMQ
+func enroll2FA(entry Payload) (jsonResponse, error) {
+	// create some json we'll send to the auth microservice
+	jsonData, err := json.MarshalIndent(entry.TwoFactor, "", "\t")
+	if err != nil {
+		return jsonResponse{Error: true, Message: fmt.Sprintf("error %v", err)}, err
+	}
+
+	// call the service
+	request, err := http.NewRequest("POST", "http://authentication-service/enroll_2fa", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return jsonResponse{Error: true, Message: fmt.Sprintf("error %v", err)}, err
+	}
+
+	return handleSync(request, http.StatusOK)
+}
+
+// confirm2FA enables two-factor authentication of user via RabbitMQ
+func confirm2FA(entry Payload) (jsonResponse, error) {
+	// create some json we'll send to the auth microservice
+	jsonData, err := json.MarshalIndent(entry.TwoFactor, "", "\t")
+	if err != nil {
+		return jsonResponse{Error: true, Message: fmt.Sprintf("error %v", err)}, err
+	}
+
+	// call the service
+	request, err := http.NewRequest("POST", "http://authentication-service/confirm_2fa", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return jsonResponse{Error: true, Message: fmt.Sprintf("error %v", err)}, err
+	}
+
+	return handleSync(request, http.StatusOK)
+}
+
+// authenticateUser2FA completes login with second factor via RabbitMQ
+func authenticateUser2FA(entry Payload) (jsonResponse, error) {
+	// create some json we'll send to the auth microservice
+	jsonData, err := json.MarshalIndent(entry.TwoFactor, "", "\t")
+	if err != nil {
+		return jsonResponse{Error: true, Message: fmt.Sprintf("error %v", err)}, err
+	}
+
+	// call the service
+	request, err := http.NewRequest("POST", "http://authentication-service/authenticate_2fa", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return jsonResponse{Error: true, Message: fmt.Sprintf("error %v", err)}, err
+	}
+
+	return handleSync(request, http.StatusOK)
+}
+
+// restoreUser restores soft deleted user by ID via RabbitMQ
+func restoreUser(entry Payload) (jsonResponse, error) {
+	// create some json we'll send to the auth microservice
+	jsonData, err := json.MarshalIndent(entry.ID, "", "\t")
+	if err != nil {
+		return jsonResponse{Error: true, Message: fmt.Sprintf("error %v", err)}, err
+	}
+
+	// call the service
+	request, err := http.NewRequest("PUT", "http://authentication-service/restore_by_id", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return jsonResponse{Error: true, Message: fmt.Sprintf("error %v", err)}, err
+	}
+
+	return handleSync(request, http.StatusOK)
+}
+
+// eraseUser permanently deletes user by ID and erases its data via RabbitMQ
+func eraseUser(entry Payload) (jsonResponse, error) {
+	// create some json we'll send to the auth microservice
+	jsonData, err := json.MarshalIndent(entry.ID, "", "\t")
+	if err != nil {
+		return jsonResponse{Error: true, Message: fmt.Sprintf("error %v", err)}, err
+	}
+
+	// call the service
+	request, err := http.NewRequest("DELETE", "http://authentication-service/erase_by_id", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return jsonResponse{Error: true, Message: fmt.Sprintf("error %v", err)}, err
+	}
+
+	return handleSync(request, http.StatusOK)
+}
+
+// confirmEmailChange changes email of user by confirmation token via RabbitMQ
+func confirmEmailChange(entry Payload) (jsonResponse, error) {
+	// create some json we'll send to the auth microservice
+	jsonData, err := json.MarshalIndent(entry.Token, "", "\t")
+	if err != nil {
+		return jsonResponse{Error: true, Message: fmt.Sprintf("error %v", err)}, err
+	}
+
+	// call the service
+	request, err := http.NewRequest("POST", "http://authentication-service/confirm_email_change", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return jsonResponse{Error: true, Message: fmt.Sprintf("error %v", err)}, err
+	}
+
+	return handleSync(request, http.StatusOK)
+}
+
+// oidcStart starts login with external identity provider via RabbitMQ
+func oidcStart(entry Payload) (jsonResponse, error) {
+	// create some json we'll send to the auth microservice
+	jsonData, err := json.MarshalIndent(entry.OIDC, "", "\t")
+	if err != nil {
+		return jsonResponse{Error: true, Message: fmt.Sprintf("error %v", err)}, err
+	}
+
+	// call the service
+	request, err := http.NewRequest("POST", "http://authentication-service/oidc_start", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return jsonResponse{Error: true, Message: fmt.Sprintf("error %v", err)}, err
+	}
+
+	return handleSync(request, http.StatusOK)
+}
+
+// oidcCallback finishes login with external identity provider via RabbitMQ
+func oidcCallback(entry Payload) (jsonResponse, error) {
+	// create some json we'll send to the auth microservice
+	jsonData, err := json.MarshalIndent(entry.OIDC, "", "\t")
+	if err != nil {
+		return jsonResponse{Error: true, Message: fmt.Sprintf("error %v", err)}, err
+	}
+
+	// call the service
+	request, err := http.NewRequest("POST", "http://authentication-service/oidc_callback", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return jsonResponse{Error: true, Message: fmt.Sprintf("error %v", err)}, err
+	}
+
+	return handleSync(request, http.StatusOK)
+}
+
+// createAPIKey creates API key of user via RabbitMQ
+func createAPIKey(entry Payload) (jsonResponse, error) {
+	// create some json we'll send to the auth microservice
+	jsonData, err := json.MarshalIndent(entry.APIKey, "", "\t")
+	if err != nil {
+		return jsonResponse{Error: true, Message: fmt.Sprintf("error %v", err)}, err
+	}
+
+	// call the service
+	request, err := http.NewRequest("POST", "http://authentication-service/create_api_key", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return jsonResponse{Error: true, Message: fmt.Sprintf("error %v", err)}, err
+	}
+
+	return handleSync(request, http.StatusCreated)
+}
+
+// getAPIKeys returns API keys of user via RabbitMQ
+func getAPIKeys(entry Payload) (jsonResponse, error) {
+	// create some json we'll send to the auth microservice
+	jsonData, err := json.MarshalIndent(entry.APIKey, "", "\t")
+	if err != nil {
+		return jsonResponse{Error: true, Message: fmt.Sprintf("error %v", err)}, err
+	}
+
+	// call the service
+	request, err := http.NewRequest("POST", "http://authentication-service/get_api_keys", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return jsonResponse{Error: true, Message: fmt.Sprintf("error %v", err)}, err
+	}
+
+	return handleSync(request, http.StatusOK)
+}
+
+// revokeAPIKey revokes API key of user via RabbitMQ
+func revokeAPIKey(entry Payload) (jsonResponse, error) {
+	// create some json we'll send to the auth microservice
+	jsonData, err := json.MarshalIndent(entry.APIKey, "", "\t")
+	if err != nil {
+		return jsonResponse{Error: true, Message: fmt.Sprintf("error %v", err)}, err
+	}
+
+	// call the service
+	request, err := http.NewRequest("POST", "http://authentication-service/revoke_api_key", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return jsonResponse{Error: true, Message: fmt.Sprintf("error %v", err)}, err
+	}
+
+	return handleSync(request, http.StatusOK)
+}
+
+// importUsers imports users from CSV or JSON via RabbitMQ
+func importUsers(entry Payload) (jsonResponse, error) {
+	// create some json we'll send to the auth microservice
+	jsonData, err := json.MarshalIndent(entry.ImportUsers, "", "\t")
+	if err != nil {
+		return jsonResponse{Error: true, Message: fmt.Sprintf("error %v", err)}, err
+	}
+
+	// call the service
+	request, err := http.NewRequest("POST", "http://authentication-service/import_users", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return jsonResponse{Error: true, Message: fmt.Sprintf("error %v", err)}, err
+	}
+
+	return handleSync(request, http.StatusOK)
+}
+
+// getLogs returns page of logs via RabbitMQ
+func getLogs(entry Payload) (jsonResponse, error) {
+	f := entry.LogFilter
+
+	query := url.Values{}
+	for key, value := range map[string]string{
+		"name":     f.Name,
+		"level":    f.Level,
+		"service":  f.Service,
+		"user_id":  f.UserID,
+		"trace_id": f.TraceID,
+		"from":     f.From,
+		"to":       f.To,
+		"search":   f.Search,
+		"sort":     f.Sort,
+		"cursor":   f.Cursor,
+	} {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+	if f.Limit != 0 {
+		query.Set("limit", strconv.Itoa(f.Limit))
+	}
+
+	// call the service
+	request, err := http.NewRequest("GET", "http://logger-service/logs?"+query.Encode(), nil)
+	if err != nil {
+		return jsonResponse{Error: true, Message: fmt.Sprintf("error %v", err)}, err
+	}
+
+	return handleSync(request, http.StatusOK)
+}
+
+// getLogStats returns counts of logs via RabbitMQ
+func getLogStats(entry Payload) (jsonResponse, error) {
+	// create some json we'll send to the logger microservice
+	jsonData, err := json.MarshalIndent(entry.LogStats, "", "\t")
+	if err != nil {
+		return jsonResponse{Error: true, Message: fmt.Sprintf("error %v", err)}, err
+	}
+
+	// call the service
+	request, err := http.NewRequest("POST", "http://logger-service/logs/stats", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return jsonResponse{Error: true, Message: fmt.Sprintf("error %v", err)}, err
+	}
+
+	return handleSync(request, http.StatusOK)
+}
+
+// verifyAudit checks hash chain of audit log via RabbitMQ
+func verifyAudit() (jsonResponse, error) {
+	request, err := http.NewRequest("GET", "http://logger-service/audit/verify", nil)
+	if err != nil {
+		return jsonResponse{Error: true, Message: fmt.Sprintf("error %v", err)}, err
+	}
+
+	return handleSync(request, http.StatusOK)
+}
+
 // handleAsync is template of async request
 func handleAsync(request *http.Request) error {
 	request.Header.Set("Content-Type", "application/json")
@@ -535,6 +1167,12 @@ func handleSync(request *http.Request, code int) (jsonResponse, error) {
 		err = errors.New("unauthorized")
 		return jsonResponse{Error: true, Message: fmt.Sprintf("%v", err)}, err
 	} else if response.StatusCode != code {
+		// pass error of service to client if service explained it
+		jsonService := jsonResponse{}
+		if json.NewDecoder(response.Body).Decode(&jsonService) == nil && jsonService.Error {
+			return jsonService, errors.New(jsonService.Message)
+		}
+
 		err = errors.New("service don`t work")
 		return jsonResponse{Error: true, Message: fmt.Sprintf("%v", err)}, err
 	}
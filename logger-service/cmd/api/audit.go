@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"logger-service/data"
+	"net/http"
+	"strconv"
+)
+
+func (app *Config) WriteAudit(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Action  string `json:"action"`
+		UserID  string `json:"user_id,omitempty"`
+		Service string `json:"service,omitempty"`
+		Data    string `json:"data,omitempty"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	record, err := app.Models.AuditRecord.Append(data.AuditRecord{
+		Action:  requestPayload.Action,
+		UserID:  requestPayload.UserID,
+		Service: requestPayload.Service,
+		Data:    requestPayload.Data,
+	})
+	if err != nil {
+		if errors.Is(err, data.ErrAuditNotReady) {
+			app.errorJSON(w, err, http.StatusServiceUnavailable)
+			return
+		}
+		app.errorJSON(w, err)
+		return
+	}
+
+	resp := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("audit record %d appended", record.Seq),
+		Data:    record,
+	}
+
+	app.writeJSON(w, http.StatusCreated, resp)
+}
+
+// GetAudit returns records after seq of after parameter
+func (app *Config) GetAudit(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+
+	var after int64
+	if value := qs.Get("after"); value != "" {
+		v, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || v < 0 {
+			app.errorJSON(w, errors.New("after must be not negative integer"))
+			return
+		}
+		after = v
+	}
+
+	limit := 50
+	if value := qs.Get("limit"); value != "" {
+		v, err := strconv.Atoi(value)
+		if err != nil || v < 1 || v > 500 {
+			app.errorJSON(w, errors.New("limit must be between 1 and 500"))
+			return
+		}
+		limit = v
+	}
+
+	records, err := app.Models.AuditRecord.Find(after, limit)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("got %d audit records", len(records)),
+		Data:    records,
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+func (app *Config) VerifyAudit(w http.ResponseWriter, r *http.Request) {
+	result, err := app.Models.AuditRecord.Verify()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	message := fmt.Sprintf("audit chain of %d records is valid", result.Checked)
+	if !result.Valid {
+		message = fmt.Sprintf("audit chain is broken, found %d problems", len(result.Problems))
+	}
+
+	resp := jsonResponse{
+		Error:   false,
+		Message: message,
+		Data:    result,
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
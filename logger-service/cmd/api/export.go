@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"logger-service/data"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ExportLogs streams entries matching filters of GetLogs as NDJSON or CSV
+func (app *Config) ExportLogs(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "ndjson"
+	}
+
+	if format != "ndjson" && format != "csv" {
+		app.errorJSON(w, fmt.Errorf("unknown format %q, format must be ndjson or csv", format))
+		return
+	}
+
+	filter, err := readLogFilter(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	fileName := fmt.Sprintf("logs-%s.%s", time.Now().UTC().Format("20060102T150405Z"), format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName))
+
+	var count int
+
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		count, err = app.exportCSV(w, r, filter)
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		count, err = app.exportNDJSON(w, r, filter)
+	}
+
+	// status is already sent, so error can only be logged
+	if err != nil {
+		log.Println("Error exporting logs:", err)
+		return
+	}
+
+	log.Printf("Exported %d log entries as %s", count, format)
+}
+
+func (app *Config) exportNDJSON(w http.ResponseWriter, r *http.Request, filter data.LogFilter) (int, error) {
+	enc := json.NewEncoder(w)
+	count := 0
+
+	err := app.Models.LogEntry.Export(r.Context(), filter, func(entry *data.LogEntry) error {
+		// encoder ends every entry with new line
+		err := enc.Encode(entry)
+		if err != nil {
+			return err
+		}
+
+		count++
+		if count%500 == 0 {
+			flush(w)
+		}
+
+		return nil
+	})
+
+	return count, err
+}
+
+func (app *Config) exportCSV(w http.ResponseWriter, r *http.Request, filter data.LogFilter) (int, error) {
+	writer := csv.NewWriter(w)
+
+	err := writer.Write([]string{"id", "created_at", "timestamp", "level", "service", "name", "user_id", "trace_id", "data", "fields"})
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+
+	err = app.Models.LogEntry.Export(r.Context(), filter, func(entry *data.LogEntry) error {
+		// fields are written as json object in one column
+		var fields string
+		if len(entry.Fields) > 0 {
+			out, err := json.Marshal(entry.Fields)
+			if err != nil {
+				return err
+			}
+			fields = string(out)
+		}
+
+		err := writer.Write([]string{
+			entry.ID,
+			entry.CreatedAt.UTC().Format(time.RFC3339Nano),
+			entry.Timestamp.UTC().Format(time.RFC3339Nano),
+			entry.Level,
+			csvCell(entry.Service),
+			csvCell(entry.Name),
+			csvCell(entry.UserID),
+			csvCell(entry.TraceID),
+			csvCell(entry.Data),
+			fields,
+		})
+		if err != nil {
+			return err
+		}
+
+		count++
+		if count%500 == 0 {
+			writer.Flush()
+			flush(w)
+		}
+
+		return writer.Error()
+	})
+	if err != nil {
+		return count, err
+	}
+
+	writer.Flush()
+
+	return count, writer.Error()
+}
+
+// csvCell escapes cell which spreadsheet would run as formula, so logged values can`t inject formulas
+func csvCell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@", rune(value[0])) {
+		return "'" + value
+	}
+
+	return value
+}
+
+// flush sends written part of response to client
+func flush(w http.ResponseWriter) {
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -2,8 +2,11 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"log"
 	"logger-service/data"
 	"logger-service/logs"
@@ -13,6 +16,7 @@ import (
 type LogServer struct {
 	logs.UnimplementedLogServiceServer
 	Models data.Models
+	Buffer *data.Buffer
 }
 
 func (l *LogServer) WriteLog(ctx context.Context, req *logs.LogRequest) (*logs.LogResponse, error) {
@@ -20,14 +24,32 @@ func (l *LogServer) WriteLog(ctx context.Context, req *logs.LogRequest) (*logs.L
 
 	// write the log
 	logEntry := data.LogEntry{
-		Name: input.Name,
-		Data: input.Data,
+		UserID:  input.GetUserId(),
+		Name:    input.GetName(),
+		Data:    input.GetData(),
+		Level:   input.GetLevel(),
+		Service: input.GetService(),
+		TraceID: input.GetTraceId(),
 	}
 
-	err := l.Models.LogEntry.Insert(logEntry)
+	if input.GetTimestamp() != nil {
+		logEntry.Timestamp = input.GetTimestamp().AsTime()
+	}
+
+	if len(input.GetFields()) > 0 {
+		logEntry.Fields = make(map[string]any, len(input.GetFields()))
+		for key, value := range input.GetFields() {
+			logEntry.Fields[key] = value
+		}
+	}
+
+	err := l.Buffer.Add(logEntry)
 	if err != nil {
 		res := &logs.LogResponse{Result: "failed"}
-		return res, err
+		if errors.Is(err, data.ErrBufferFull) || errors.Is(err, data.ErrBufferClosed) {
+			return res, status.Error(codes.ResourceExhausted, err.Error())
+		}
+		return res, status.Error(codes.InvalidArgument, err.Error())
 	}
 
 	// return response
@@ -35,7 +57,8 @@ func (l *LogServer) WriteLog(ctx context.Context, req *logs.LogRequest) (*logs.L
 	return res, nil
 }
 
-func (app *Config) gRPCListen() {
+// gRPCListen starts gRPC server in background and returns it, so it can be stopped on shutdown
+func (app *Config) gRPCListen() *grpc.Server {
 	lis, err := net.Listen("tcp", fmt.Sprintf(":%s", gRpcPort))
 	if err != nil {
 		log.Fatalf("Failed to listen for gRPC: %v", err)
@@ -43,11 +66,15 @@ func (app *Config) gRPCListen() {
 
 	s := grpc.NewServer()
 
-	logs.RegisterLogServiceServer(s, &LogServer{Models: app.Models})
+	logs.RegisterLogServiceServer(s, &LogServer{Models: app.Models, Buffer: app.Buffer})
 
 	log.Printf("gRPC Server started on port %s", gRpcPort)
 
-	if err := s.Serve(lis); err != nil {
-		log.Fatalf("Failed to listen for gRPC: %v", err)
-	}
+	go func() {
+		if err := s.Serve(lis); err != nil {
+			log.Fatalf("Failed to listen for gRPC: %v", err)
+		}
+	}()
+
+	return s
 }
@@ -1,36 +1,291 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/mongo"
 	"logger-service/data"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 type JSONPayload struct {
-	Name string `json:"name"`
-	Data string `json:"data"`
+	UserID    string         `json:"user_id,omitempty"`
+	Name      string         `json:"name"`
+	Data      string         `json:"data"`
+	Level     string         `json:"level,omitempty"`
+	Service   string         `json:"service,omitempty"`
+	TraceID   string         `json:"trace_id,omitempty"`
+	Fields    map[string]any `json:"fields,omitempty"`
+	Timestamp time.Time      `json:"timestamp"`
 }
 
+// maxBatchSize is the maximum number of entries in one batch request
+const maxBatchSize = 1000
+
 func (app *Config) WriteLog(w http.ResponseWriter, r *http.Request) {
 	// read json into var
 	var requestPayload JSONPayload
-	app.readJSON(w, r, &requestPayload)
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	// buffer data, it is inserted in batch
+	err = app.Buffer.Add(requestPayload.entry())
+	if err != nil {
+		app.bufferError(w, err)
+		return
+	}
 
-	// insert data
-	event := data.LogEntry{
-		Name: requestPayload.Name,
-		Data: requestPayload.Data,
+	resp := jsonResponse{
+		Error:   false,
+		Message: "logged",
 	}
 
-	err := app.Models.LogEntry.Insert(event)
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// WriteLogs accepts array of entries. All entries are validated first and buffered together, so batch isn`t
+// written partly
+func (app *Config) WriteLogs(w http.ResponseWriter, r *http.Request) {
+	var requestPayload []JSONPayload
+	err := app.readJSON(w, r, &requestPayload)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
+	if len(requestPayload) == 0 || len(requestPayload) > maxBatchSize {
+		app.errorJSON(w, fmt.Errorf("batch must have from 1 to %d entries", maxBatchSize))
+		return
+	}
+
+	entries := make([]data.LogEntry, len(requestPayload))
+	for i, payload := range requestPayload {
+		entries[i] = payload.entry()
+	}
+
+	// entries are validated by buffer, batch is accepted whole or not at all, so client retrying
+	// after 503 doesn`t duplicate entries
+	err = app.Buffer.AddBatch(entries)
+	if err != nil {
+		app.bufferError(w, err)
+		return
+	}
+
 	resp := jsonResponse{
 		Error:   false,
-		Message: "logged",
+		Message: fmt.Sprintf("logged %d entries", len(entries)),
+		Data:    len(entries),
 	}
 
 	app.writeJSON(w, http.StatusAccepted, resp)
 }
+
+func (p JSONPayload) entry() data.LogEntry {
+	return data.LogEntry{
+		UserID:    p.UserID,
+		Name:      p.Name,
+		Data:      p.Data,
+		Level:     p.Level,
+		Service:   p.Service,
+		TraceID:   p.TraceID,
+		Fields:    p.Fields,
+		Timestamp: p.Timestamp,
+	}
+}
+
+// bufferError writes 503 if buffer can`t take entry now, so client retries later, and 400 if entry is invalid
+func (app *Config) bufferError(w http.ResponseWriter, err error) {
+	if errors.Is(err, data.ErrBufferFull) || errors.Is(err, data.ErrBufferClosed) {
+		payload := jsonResponse{Error: true, Message: err.Error()}
+		app.writeJSON(w, http.StatusServiceUnavailable, payload, retryAfter())
+		return
+	}
+
+	app.errorJSON(w, err)
+}
+
+func retryAfter() http.Header {
+	return http.Header{"Retry-After": []string{"1"}}
+}
+
+func (app *Config) EraseLogs(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		UserID string `json:"user_id"`
+		Email  string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil || requestPayload.UserID == "" || requestPayload.Email == "" {
+		app.errorJSON(w, fmt.Errorf("user_id and email are required"))
+		return
+	}
+
+	deleted, err := app.Models.LogEntry.DeleteByUser(requestPayload.UserID, requestPayload.Email)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	resp := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("erased %d log entries", deleted),
+		Data:    deleted,
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+func (app *Config) BackfillLogs(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		UserID string `json:"user_id"`
+		Email  string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil || requestPayload.UserID == "" || requestPayload.Email == "" {
+		app.errorJSON(w, fmt.Errorf("user_id and email are required"))
+		return
+	}
+
+	updated, err := app.Models.LogEntry.Backfill(requestPayload.UserID, requestPayload.Email)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	resp := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("backfilled %d log entries", updated),
+		Data:    updated,
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+func (app *Config) GetLogs(w http.ResponseWriter, r *http.Request) {
+	filter, err := readLogFilter(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	entries, next, err := app.Models.LogEntry.Find(filter)
+	if err != nil {
+		if errors.Is(err, data.ErrInvalidCursor) {
+			app.errorJSON(w, err)
+			return
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("got %d log entries", len(entries)),
+		Data: struct {
+			Logs       []*data.LogEntry `json:"logs"`
+			NextCursor string           `json:"next_cursor,omitempty"`
+		}{
+			Logs:       entries,
+			NextCursor: next,
+		},
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+func (app *Config) GetLog(w http.ResponseWriter, r *http.Request) {
+	entry, err := app.Models.LogEntry.GetOne(chi.URLParam(r, "id"))
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			app.errorJSON(w, errors.New("log entry not found"), http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err)
+		return
+	}
+
+	resp := jsonResponse{
+		Error:   false,
+		Message: "got log entry",
+		Data:    entry,
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+func (app *Config) GetLogStats(w http.ResponseWriter, r *http.Request) {
+	var requestPayload data.StatsQuery
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = requestPayload.Validate()
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	buckets, err := app.Models.LogEntry.Stats(requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("got %d buckets", len(buckets)),
+		Data:    buckets,
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// readLogFilter reads filter of logs from query parameters, time range is in RFC 3339
+func readLogFilter(r *http.Request) (data.LogFilter, error) {
+	qs := r.URL.Query()
+
+	filter := data.LogFilter{
+		Name:    qs.Get("name"),
+		Level:   qs.Get("level"),
+		Service: qs.Get("service"),
+		UserID:  qs.Get("user_id"),
+		TraceID: qs.Get("trace_id"),
+		Search:  qs.Get("search"),
+		Sort:    qs.Get("sort"),
+		Cursor:  qs.Get("cursor"),
+	}
+
+	for key, dest := range map[string]**time.Time{"from": &filter.From, "to": &filter.To} {
+		if value := qs.Get(key); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return filter, fmt.Errorf("%s must be RFC 3339 time", key)
+			}
+			*dest = &t
+		}
+	}
+
+	if value := qs.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil {
+			return filter, errors.New("limit must be integer")
+		}
+		filter.Limit = limit
+	}
+
+	err := filter.Validate()
+	if err != nil {
+		return filter, err
+	}
+
+	return filter, nil
+}
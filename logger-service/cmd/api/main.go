@@ -11,6 +11,10 @@ import (
 	"net/http"
 	"net/rpc"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 )
 
@@ -25,36 +29,63 @@ var client *mongo.Client
 
 type Config struct {
 	Models data.Models
+	Buffer *data.Buffer
+	Hub    *data.Hub
+	Mongo  bool
 }
 
 func main() {
-	// connect to mongo
-	mongoClient, err := connectToMongo()
-	if err != nil {
-		log.Panic(err)
-	}
-	client = mongoClient
+	configureRedaction()
 
-	// create a context in order to disconnect
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-	defer cancel()
+	sinks, useMongo := newSinks()
 
-	// close connection
-	defer func() {
-		if err = client.Disconnect(ctx); err != nil {
-			panic(err)
+	// connect to mongo, service runs without it if logs aren`t stored there
+	if useMongo {
+		mongoClient, err := connectToMongo()
+		if err != nil {
+			log.Panic(err)
 		}
-	}()
+		client = mongoClient
+	}
+
+	hub := data.NewHub()
 
 	app := Config{
 		Models: data.New(client),
+		Buffer: newBuffer(sinks, hub),
+		Hub:    hub,
+		Mongo:  useMongo,
+	}
+
+	if app.Mongo {
+		retention, err := data.ParseRetention(os.Getenv("LOG_RETENTION"))
+		if err != nil {
+			log.Panic(err)
+		}
+
+		// audit key and head are kept outside of mongo, so chain can`t be rebuilt or cut by who can write to it
+		err = data.SetAuditKey([]byte(os.Getenv("AUDIT_HMAC_KEY")), os.Getenv("AUDIT_HEAD_FILE"))
+		if err != nil {
+			log.Panic("AUDIT_HMAC_KEY: ", err)
+		}
+
+		// unique seq keeps audit chain linear, so audit records aren`t taken until it exists
+		err = app.Models.AuditRecord.EnsureIndexes()
+		if err != nil {
+			log.Panic("Error creating audit indexes: ", err)
+		}
+
+		// indexes are built in background, so service takes logs while index of big collection is built
+		go app.ensureIndexes(retention)
 	}
 
+	go app.Buffer.Run()
+
 	// Register the RPC Server
-	err = rpc.Register(new(RPCServer))
+	err := rpc.Register(&RPCServer{Models: app.Models, Buffer: app.Buffer})
 	go app.rpcListen()
 
-	go app.gRPCListen()
+	grpcServer := app.gRPCListen()
 
 	// start web server
 	log.Println("Starting service on port", webPort)
@@ -63,10 +94,186 @@ func main() {
 		Handler: app.routes(),
 	}
 
-	err = srv.ListenAndServe()
+	// end live tails, otherwise shutdown waits for them
+	srv.RegisterOnShutdown(app.Hub.Close)
+
+	go func() {
+		err := srv.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			log.Panic(err)
+		}
+	}()
+
+	// wait for signal to stop
+	stop, cancelStop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancelStop()
+	<-stop.Done()
+
+	log.Println("Shutting down")
+
+	// create a context in order to shut down
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	// stop taking new entries, then flush buffered entries before disconnecting
+	err = srv.Shutdown(ctx)
+	if err != nil {
+		log.Println("Error shutting down web server:", err)
+	}
+
+	grpcServer.GracefulStop()
+
+	err = app.Buffer.Close(ctx)
+	if err != nil {
+		log.Println("Error flushing log buffer:", err)
+	}
+
+	// close connection
+	if client != nil {
+		if err = client.Disconnect(ctx); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// ensureIndexes creates indexes and reports status of every index
+func (app *Config) ensureIndexes(retention []data.RetentionPolicy) {
+	log.Println("Building indexes")
+
+	statuses, err := app.Models.LogEntry.EnsureIndexes(retention)
+	for _, s := range statuses {
+		if s.TTL != "" {
+			log.Printf("Index %s: %s, ttl %s", s.Name, s.Status, s.TTL)
+			continue
+		}
+		log.Printf("Index %s: %s", s.Name, s.Status)
+	}
+	if err != nil {
+		log.Println("Error creating indexes:", err)
+		return
+	}
+
+	log.Println("Indexes are ready")
+}
+
+// configureRedaction reads redaction rules from LOG_REDACT_FIELDS, which replaces default field
+// names, LOG_REDACT_PATTERNS, which adds regular expressions separated by new lines, LOG_MASK_EMAILS
+// and LOG_EMAIL_HASH_KEY, which is required if emails are masked
+func configureRedaction() {
+	rules := data.DefaultRedactionRules()
+
+	if env := os.Getenv("LOG_REDACT_FIELDS"); env != "" {
+		rules.Fields = strings.Split(env, ",")
+	}
+
+	if env := os.Getenv("LOG_REDACT_PATTERNS"); env != "" {
+		rules.Patterns = append(rules.Patterns, strings.Split(env, "\n")...)
+	}
+
+	if env := os.Getenv("LOG_MASK_EMAILS"); env != "" {
+		v, err := strconv.ParseBool(env)
+		if err != nil {
+			log.Panic("LOG_MASK_EMAILS must be true or false")
+		}
+		rules.MaskEmails = v
+	}
+
+	// key must stay the same, otherwise entries with emails masked before can`t be erased
+	rules.EmailHashKey = []byte(os.Getenv("LOG_EMAIL_HASH_KEY"))
+
+	redactor, err := data.NewRedactor(rules)
 	if err != nil {
 		log.Panic(err)
 	}
+
+	data.SetRedactor(redactor)
+}
+
+// newSinks returns sinks listed in LOG_SINKS, which is mongo by default, and reports whether
+// mongo is one of them. File sink is configured by LOG_FILE_PATH, LOG_FILE_MAX_SIZE in megabytes
+// and LOG_FILE_MAX_BACKUPS
+func newSinks() ([]data.Sink, bool) {
+	names := os.Getenv("LOG_SINKS")
+	if names == "" {
+		names = "mongo"
+	}
+
+	var sinks []data.Sink
+	useMongo := false
+	seen := make(map[string]bool)
+
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if seen[name] {
+			log.Panicf("duplicate log sink %q", name)
+		}
+		seen[name] = true
+
+		switch name {
+		case "mongo":
+			sinks = append(sinks, &data.MongoSink{})
+			useMongo = true
+		case "stdout":
+			sinks = append(sinks, data.NewStdoutSink())
+		case "file":
+			path := os.Getenv("LOG_FILE_PATH")
+			if path == "" {
+				path = "/var/log/logger-service/logs.jsonl"
+			}
+
+			maxSize, maxBackups := 100, 5
+			for env, dest := range map[string]*int{"LOG_FILE_MAX_SIZE": &maxSize, "LOG_FILE_MAX_BACKUPS": &maxBackups} {
+				if value := os.Getenv(env); value != "" {
+					v, err := strconv.Atoi(value)
+					if err != nil || v < 0 {
+						log.Panicf("%s must be not negative integer", env)
+					}
+					*dest = v
+				}
+			}
+			if maxSize == 0 {
+				log.Panic("LOG_FILE_MAX_SIZE must be positive integer")
+			}
+
+			sink, err := data.NewFileSink(path, int64(maxSize)*1024*1024, maxBackups)
+			if err != nil {
+				log.Panic(err)
+			}
+			sinks = append(sinks, sink)
+		default:
+			log.Panicf("unknown log sink %q, must be mongo, file or stdout", name)
+		}
+	}
+
+	log.Println("Writing logs to", names)
+
+	return sinks, useMongo
+}
+
+// newBuffer returns log buffer configured by LOG_BUFFER_SIZE, LOG_BATCH_SIZE and LOG_FLUSH_INTERVAL
+func newBuffer(sinks []data.Sink, hub *data.Hub) *data.Buffer {
+	size, batch := 10000, 500
+	interval := time.Second
+
+	for name, dest := range map[string]*int{"LOG_BUFFER_SIZE": &size, "LOG_BATCH_SIZE": &batch} {
+		if env := os.Getenv(name); env != "" {
+			v, err := strconv.Atoi(env)
+			if err != nil || v < 1 {
+				log.Panicf("%s must be positive integer", name)
+			}
+			*dest = v
+		}
+	}
+
+	if env := os.Getenv("LOG_FLUSH_INTERVAL"); env != "" {
+		v, err := time.ParseDuration(env)
+		if err != nil || v <= 0 {
+			log.Panic("LOG_FLUSH_INTERVAL must be positive duration")
+		}
+		interval = v
+	}
+
+	return data.NewBuffer(size, batch, interval, 2*time.Second, sinks, hub)
 }
 
 func (app *Config) rpcListen() error {
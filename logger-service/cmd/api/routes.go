@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
@@ -23,6 +24,37 @@ func (app *Config) routes() http.Handler {
 	mux.Use(middleware.Heartbeat("/ping"))
 
 	mux.Post("/log", app.WriteLog)
+	mux.Post("/log/batch", app.WriteLogs)
+	mux.Get("/logs/tail", app.TailLogs)
+
+	// stored logs can be read and changed only in mongo
+	mux.Group(func(mux chi.Router) {
+		mux.Use(app.requireMongo)
+
+		mux.Post("/erase", app.EraseLogs)
+		mux.Post("/backfill", app.BackfillLogs)
+		mux.Get("/logs", app.GetLogs)
+		mux.Get("/logs/export", app.ExportLogs)
+		mux.Post("/logs/stats", app.GetLogStats)
+		mux.Get("/logs/{id}", app.GetLog)
+
+		// audit records can only be appended and read
+		mux.Post("/audit", app.WriteAudit)
+		mux.Get("/audit", app.GetAudit)
+		mux.Get("/audit/verify", app.VerifyAudit)
+	})
 
 	return mux
 }
+
+// requireMongo responds 503 if service runs without mongo sink
+func (app *Config) requireMongo(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.Mongo {
+			app.errorJSON(w, errors.New("logs are not stored in mongo"), http.StatusServiceUnavailable)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -1,33 +1,53 @@
 package main
 
 import (
-	"context"
+	"encoding/gob"
 	"log"
 	"logger-service/data"
 	"time"
 )
 
+// nested objects and arrays of Fields are decoded from JSON into these types, gob can send them
+// as values of interface only when they are registered
+func init() {
+	gob.Register(map[string]any{})
+	gob.Register([]any{})
+}
+
 // RPCServer is the type for out RPC Server. Methods that take this as a receiver are available
 // over RPC, as long as they are exported
 type RPCServer struct {
+	Models data.Models
+	Buffer *data.Buffer
 }
 
-// RPCPayload is the type for data we receive from RPC
+// RPCPayload is the type for data we receive from RPC. Clients which send only Name and Data
+// still work, gob skips missing fields
 type RPCPayload struct {
-	Name string
-	Data string
+	Name      string
+	Data      string
+	Level     string
+	Service   string
+	Timestamp time.Time
+	TraceID   string
+	UserID    string
+	Fields    map[string]any
 }
 
-// LogInfo writes our payload to mongo
+// LogInfo puts our payload into buffer, which writes it to mongo
 func (r *RPCServer) LogInfo(payload RPCPayload, resp *string) error {
-	collection := client.Database("logs").Collection("logs")
-	_, err := collection.InsertOne(context.TODO(), data.LogEntry{
+	err := r.Buffer.Add(data.LogEntry{
+		UserID:    payload.UserID,
 		Name:      payload.Name,
 		Data:      payload.Data,
-		CreatedAt: time.Now(),
+		Level:     payload.Level,
+		Service:   payload.Service,
+		TraceID:   payload.TraceID,
+		Fields:    payload.Fields,
+		Timestamp: payload.Timestamp,
 	})
 	if err != nil {
-		log.Println("error writing to mongo", err)
+		log.Println("error buffering log entry", err)
 		return err
 	}
 
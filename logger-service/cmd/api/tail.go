@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// heartbeatInterval is how often comment is sent to idle stream, so proxies don`t close it
+const heartbeatInterval = 15 * time.Second
+
+// TailLogs streams new log entries as server-sent events. It takes the same filters as GetLogs,
+// limit, sort and cursor are ignored
+func (app *Config) TailLogs(w http.ResponseWriter, r *http.Request) {
+	filter, err := readLogFilter(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		app.errorJSON(w, errors.New("streaming is not supported"), http.StatusInternalServerError)
+		return
+	}
+
+	sub := app.Hub.Subscribe(filter)
+	defer app.Hub.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case entry, ok := <-sub.Entries:
+			if !ok {
+				return
+			}
+
+			// client is told how many entries it missed because it was too slow
+			if dropped := sub.Dropped(); dropped > 0 {
+				fmt.Fprintf(w, "event: dropped\ndata: %d\n\n", dropped)
+			}
+
+			out, err := json.Marshal(entry)
+			if err != nil {
+				log.Println("Error encoding log entry:", err)
+				continue
+			}
+
+			_, err = fmt.Fprintf(w, "id: %s\nevent: log\ndata: %s\n\n", entry.ID, out)
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-heartbeat.C:
+			_, err = fmt.Fprint(w, ": heartbeat\n\n")
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
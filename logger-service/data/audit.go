@@ -0,0 +1,400 @@
+package data
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// maxAppendRetries is how many times record is appended when other instance took its seq
+	maxAppendRetries = 5
+	// maxAuditProblems is how many problems Verify reports before it stops
+	maxAuditProblems = 100
+)
+
+// ErrAuditNotReady is returned by Append until unique index of seq is created
+var ErrAuditNotReady = errors.New("audit log is not ready, retry later")
+
+var (
+	// auditMu makes appends of this instance sequential, other instances are detected by unique seq
+	auditMu sync.Mutex
+	// auditReady is set when unique index of seq exists, without it two instances could append
+	// records with the same seq
+	auditReady atomic.Bool
+	// auditKey signs hashes of records, it is kept outside of mongo, so who can write to mongo
+	// can`t rebuild chain
+	auditKey []byte
+	// auditHeadPath is file outside of mongo with the last appended record, so records removed
+	// from the end of chain are detected
+	auditHeadPath string
+)
+
+// AuditHead is seq and hash of the last record appended by this instance
+type AuditHead struct {
+	Seq  int64  `json:"seq"`
+	Hash string `json:"hash"`
+}
+
+// SetAuditKey sets key of record hashes and file of chain head, head isn`t stored if path is empty
+func SetAuditKey(key []byte, headPath string) error {
+	if len(key) < 32 {
+		return errors.New("audit key must be at least 32 bytes")
+	}
+
+	auditKey = key
+	auditHeadPath = headPath
+
+	return nil
+}
+
+// AuditRecord is record of security event. Records are only appended, each one stores hash of
+// previous record, so changed, removed or inserted records break the chain
+type AuditRecord struct {
+	ID        string    `bson:"_id,omitempty" json:"id,omitempty"`
+	Seq       int64     `bson:"seq" json:"seq"`
+	Action    string    `bson:"action" json:"action"`
+	UserID    string    `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	Service   string    `bson:"service,omitempty" json:"service,omitempty"`
+	Data      string    `bson:"data,omitempty" json:"data,omitempty"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+	PrevHash  string    `bson:"prev_hash" json:"prev_hash"`
+	Hash      string    `bson:"hash" json:"hash"`
+}
+
+// AuditProblem describes broken record of chain
+type AuditProblem struct {
+	Seq     int64  `json:"seq"`
+	Problem string `json:"problem"`
+}
+
+// AuditVerification is result of Verify. Head is the last record of chain, it is compared with
+// head stored outside of mongo, so removed records at the end of chain are detected too
+type AuditVerification struct {
+	Valid    bool           `json:"valid"`
+	Checked  int64          `json:"checked"`
+	Head     *AuditRecord   `json:"head,omitempty"`
+	Problems []AuditProblem `json:"problems,omitempty"`
+}
+
+// hash returns HMAC-SHA256 of previous hash and fields of record. Time is in milliseconds, because
+// mongo stores it so
+func (a *AuditRecord) hash() string {
+	out, _ := json.Marshal(struct {
+		Seq       int64  `json:"seq"`
+		Action    string `json:"action"`
+		UserID    string `json:"user_id"`
+		Service   string `json:"service"`
+		Data      string `json:"data"`
+		CreatedAt int64  `json:"created_at"`
+		PrevHash  string `json:"prev_hash"`
+	}{
+		Seq:       a.Seq,
+		Action:    a.Action,
+		UserID:    a.UserID,
+		Service:   a.Service,
+		Data:      a.Data,
+		CreatedAt: a.CreatedAt.UnixMilli(),
+		PrevHash:  a.PrevHash,
+	})
+
+	mac := hmac.New(sha256.New, auditKey)
+	mac.Write(out)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func auditCollection() *mongo.Collection {
+	return client.Database("logs").Collection("audit")
+}
+
+// EnsureIndexes creates unique index of seq, which keeps chain linear when several instances append
+func (a *AuditRecord) EnsureIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	_, err := auditCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{"seq", 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	auditReady.Store(true)
+
+	return nil
+}
+
+// Append adds record to the end of chain and returns it with seq and hashes
+func (a *AuditRecord) Append(record AuditRecord) (*AuditRecord, error) {
+	if !auditReady.Load() || len(auditKey) == 0 {
+		return nil, ErrAuditNotReady
+	}
+
+	record.Action = strings.TrimSpace(record.Action)
+	if record.Action == "" {
+		return nil, errors.New("action is required")
+	}
+
+	// audit records are redacted like log entries
+	record.Data = redactor.redactString(record.Data)
+
+	auditMu.Lock()
+	defer auditMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	collection := auditCollection()
+
+	for attempt := 1; ; attempt++ {
+		last, err := lastAuditRecord(ctx, collection)
+		if err != nil {
+			return nil, err
+		}
+
+		record.ID = ""
+		record.Seq = 1
+		record.PrevHash = ""
+		if last != nil {
+			record.Seq = last.Seq + 1
+			record.PrevHash = last.Hash
+		}
+		record.CreatedAt = time.Now().UTC().Truncate(time.Millisecond)
+		record.Hash = record.hash()
+
+		result, err := collection.InsertOne(ctx, record)
+		if err != nil {
+			// other instance appended record with this seq, chain is read again
+			if mongo.IsDuplicateKeyError(err) && attempt < maxAppendRetries {
+				continue
+			}
+			return nil, err
+		}
+
+		if id, ok := result.InsertedID.(primitive.ObjectID); ok {
+			record.ID = id.Hex()
+		}
+
+		// record is appended already, so error is only logged and client doesn`t append it again
+		err = writeAuditHead(AuditHead{Seq: record.Seq, Hash: record.Hash})
+		if err != nil {
+			log.Println("Error storing audit head:", err)
+		}
+
+		return &record, nil
+	}
+}
+
+// Find returns up to limit records with seq greater than after, ordered by seq
+func (a *AuditRecord) Find(after int64, limit int) ([]*AuditRecord, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	opts := options.Find()
+	opts.SetSort(bson.D{{"seq", 1}})
+	opts.SetLimit(int64(limit))
+
+	cur, err := auditCollection().Find(ctx, bson.M{"seq": bson.M{"$gt": after}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	records := []*AuditRecord{}
+
+	for cur.Next(ctx) {
+		var item AuditRecord
+
+		err = cur.Decode(&item)
+		if err != nil {
+			return nil, err
+		}
+
+		records = append(records, &item)
+	}
+
+	return records, cur.Err()
+}
+
+// Verify reads whole chain and checks that seq has no gaps, every record points to hash of
+// previous one and hash of every record matches its fields. Chain must reach stored head
+func (a *AuditRecord) Verify() (*AuditVerification, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+
+	head, err := readAuditHead()
+	if err != nil {
+		return nil, err
+	}
+
+	opts := options.Find()
+	opts.SetSort(bson.D{{"seq", 1}})
+	opts.SetBatchSize(1000)
+
+	cur, err := auditCollection().Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	result := &AuditVerification{}
+
+	var prev *AuditRecord
+
+	for cur.Next(ctx) {
+		var item AuditRecord
+
+		err = cur.Decode(&item)
+		if err != nil {
+			return nil, err
+		}
+
+		result.Checked++
+
+		expectedSeq, expectedPrev := int64(1), ""
+		if prev != nil {
+			expectedSeq, expectedPrev = prev.Seq+1, prev.Hash
+		}
+
+		if item.Seq != expectedSeq {
+			result.Problems = append(result.Problems, AuditProblem{
+				Seq:     item.Seq,
+				Problem: "gap in chain, records before it are missing",
+			})
+		}
+		if item.PrevHash != expectedPrev {
+			result.Problems = append(result.Problems, AuditProblem{
+				Seq:     item.Seq,
+				Problem: "previous hash doesn`t match previous record",
+			})
+		}
+		if item.Hash != item.hash() {
+			result.Problems = append(result.Problems, AuditProblem{
+				Seq:     item.Seq,
+				Problem: "hash doesn`t match record, record was modified",
+			})
+		}
+		if head != nil && item.Seq == head.Seq && item.Hash != head.Hash {
+			result.Problems = append(result.Problems, AuditProblem{
+				Seq:     item.Seq,
+				Problem: "hash doesn`t match stored head, chain was rebuilt",
+			})
+		}
+
+		prev = &item
+
+		if len(result.Problems) >= maxAuditProblems {
+			break
+		}
+	}
+
+	if err = cur.Err(); err != nil {
+		return nil, err
+	}
+
+	if head != nil && len(result.Problems) < maxAuditProblems && (prev == nil || prev.Seq < head.Seq) {
+		result.Problems = append(result.Problems, AuditProblem{
+			Seq:     head.Seq,
+			Problem: "chain ends before stored head, records at the end are missing",
+		})
+	}
+
+	result.Head = prev
+	result.Valid = len(result.Problems) == 0
+
+	return result, nil
+}
+
+func lastAuditRecord(ctx context.Context, collection *mongo.Collection) (*AuditRecord, error) {
+	var last AuditRecord
+
+	opts := options.FindOne().SetSort(bson.D{{"seq", -1}})
+
+	err := collection.FindOne(ctx, bson.M{}, opts).Decode(&last)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &last, nil
+}
+
+// readAuditHead returns stored head, it is nil if head isn`t stored
+func readAuditHead() (*AuditHead, error) {
+	if auditHeadPath == "" {
+		return nil, nil
+	}
+
+	content, err := os.ReadFile(auditHeadPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var head AuditHead
+
+	err = json.Unmarshal(content, &head)
+	if err != nil {
+		return nil, fmt.Errorf("reading audit head: %w", err)
+	}
+
+	return &head, nil
+}
+
+// writeAuditHead replaces stored head if record is newer, instances sharing file may have stored
+// newer one. File is renamed into place, so crash doesn`t leave half written head
+func writeAuditHead(head AuditHead) error {
+	if auditHeadPath == "" {
+		return nil
+	}
+
+	current, err := readAuditHead()
+	if err != nil {
+		return err
+	}
+	if current != nil && current.Seq >= head.Seq {
+		return nil
+	}
+
+	content, _ := json.Marshal(head)
+
+	tmp, err := os.CreateTemp(filepath.Dir(auditHeadPath), filepath.Base(auditHeadPath)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(content)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), auditHeadPath)
+}
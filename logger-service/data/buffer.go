@@ -0,0 +1,203 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/mongo"
+	"log"
+	"sync"
+	"time"
+)
+
+var (
+	ErrBufferFull   = errors.New("log buffer is full, retry later")
+	ErrBufferClosed = errors.New("log buffer is closed")
+)
+
+// flushRetries is how many times batch is written to sink before it is dropped
+const flushRetries = 3
+
+// Buffer collects log entries in memory and writes them to sinks when batch is full
+// or flush interval passes
+type Buffer struct {
+	entries   chan LogEntry
+	batchSize int
+	interval  time.Duration
+	wait      time.Duration
+	sinks     []Sink
+	hub       *Hub
+
+	mu     sync.RWMutex
+	closed bool
+	done   chan struct{}
+
+	// sendMu is held while batch is put into entries, so free space checked for batch isn`t taken by others
+	sendMu sync.Mutex
+}
+
+// NewBuffer returns buffer which holds up to capacity entries. If buffer is full, Add waits
+// up to wait for free place, so clients are slowed down instead of losing entries.
+// Flushed entries are written to every sink and published to hub
+func NewBuffer(capacity, batchSize int, interval, wait time.Duration, sinks []Sink, hub *Hub) *Buffer {
+	return &Buffer{
+		entries:   make(chan LogEntry, capacity),
+		batchSize: batchSize,
+		interval:  interval,
+		wait:      wait,
+		sinks:     sinks,
+		hub:       hub,
+		done:      make(chan struct{}),
+	}
+}
+
+// Add validates entry and puts it into buffer
+func (b *Buffer) Add(entry LogEntry) error {
+	return b.AddBatch([]LogEntry{entry})
+}
+
+// AddBatch validates entries and puts all of them into buffer or none of them, so client which gets
+// error may retry whole batch without duplicating entries. Error of invalid entry of batch has its index
+func (b *Buffer) AddBatch(entries []LogEntry) error {
+	prepared := make([]LogEntry, len(entries))
+	for i, entry := range entries {
+		entry, err := Prepare(entry)
+		if err != nil {
+			if len(entries) > 1 {
+				return fmt.Errorf("entry %d: %w", i, err)
+			}
+			return err
+		}
+		prepared[i] = entry
+	}
+
+	if len(prepared) > cap(b.entries) {
+		return fmt.Errorf("batch of %d entries is larger than log buffer of %d entries", len(prepared), cap(b.entries))
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.closed {
+		return ErrBufferClosed
+	}
+
+	b.sendMu.Lock()
+	defer b.sendMu.Unlock()
+
+	// only Run takes entries while sendMu is held, so free space only grows
+	deadline := time.Now().Add(b.wait)
+	for cap(b.entries)-len(b.entries) < len(prepared) {
+		if time.Now().After(deadline) {
+			return ErrBufferFull
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for _, entry := range prepared {
+		b.entries <- entry
+	}
+
+	return nil
+}
+
+// Run flushes entries until buffer is closed, then flushes the rest of them
+func (b *Buffer) Run() {
+	defer close(b.done)
+
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	batch := make([]LogEntry, 0, b.batchSize)
+
+	for {
+		select {
+		case entry, ok := <-b.entries:
+			if !ok {
+				b.flush(batch)
+				return
+			}
+
+			batch = append(batch, entry)
+			if len(batch) >= b.batchSize {
+				b.flush(batch)
+				batch = make([]LogEntry, 0, b.batchSize)
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				b.flush(batch)
+				batch = make([]LogEntry, 0, b.batchSize)
+			}
+		}
+	}
+}
+
+// Close stops accepting entries, waits until all buffered entries are flushed and closes sinks
+func (b *Buffer) Close(ctx context.Context) error {
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		close(b.entries)
+	}
+	b.mu.Unlock()
+
+	select {
+	case <-b.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	var errs []error
+	for _, sink := range b.sinks {
+		err := sink.Close()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("closing %s sink: %w", sink.Name(), err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// flush writes batch to every sink, sinks are retried independently, so failed sink doesn`t get
+// entries twice into other sinks
+func (b *Buffer) flush(batch []LogEntry) {
+	if len(batch) == 0 {
+		return
+	}
+
+	written := false
+	for _, sink := range b.sinks {
+		if b.write(sink, batch) {
+			written = true
+		}
+	}
+
+	if written && b.hub != nil {
+		b.hub.Publish(batch)
+	}
+}
+
+func (b *Buffer) write(sink Sink, batch []LogEntry) bool {
+	var err error
+
+	for attempt := 1; attempt <= flushRetries; attempt++ {
+		err = sink.Write(batch)
+		if err == nil {
+			return true
+		}
+
+		log.Printf("Error writing %d log entries to %s sink, attempt %d: %v", len(batch), sink.Name(), attempt, err)
+
+		// some entries of unordered insert or lines of file are written already, retry would duplicate them
+		var bulkErr mongo.BulkWriteException
+		if errors.As(err, &bulkErr) || errors.Is(err, ErrPartialWrite) {
+			return true
+		}
+
+		time.Sleep(time.Duration(attempt) * 500 * time.Millisecond)
+	}
+
+	log.Printf("Dropped %d log entries of %s sink: %v", len(batch), sink.Name(), err)
+
+	return false
+}
@@ -0,0 +1,52 @@
+package data
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// exportTimeout is longer than timeout of queries, because export may read whole collection
+const exportTimeout = 30 * time.Minute
+
+// Export calls fn for every entry matching filter in order of its sort. Entries are read by
+// mongo cursor in batches, so they aren`t loaded in memory like All does. Limit and cursor are ignored.
+// Export is canceled with ctx, e.g. when client of export goes away
+func (l *LogEntry) Export(ctx context.Context, filter LogFilter, fn func(*LogEntry) error) error {
+	ctx, cancel := context.WithTimeout(ctx, exportTimeout)
+	defer cancel()
+
+	collection := client.Database("logs").Collection("logs")
+
+	direction := 1
+	if filter.descending() {
+		direction = -1
+	}
+
+	opts := options.Find()
+	opts.SetSort(bson.D{{filter.sortField(), direction}, {"_id", direction}})
+	opts.SetBatchSize(1000)
+
+	cur, err := collection.Find(ctx, filter.query(), opts)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		var item LogEntry
+
+		err = cur.Decode(&item)
+		if err != nil {
+			return err
+		}
+
+		err = fn(&item)
+		if err != nil {
+			return err
+		}
+	}
+
+	return cur.Err()
+}
@@ -0,0 +1,286 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// indexTimeout is longer than timeout of queries, because building index reads whole collection
+const indexTimeout = 10 * time.Minute
+
+// retentionPrefix is prefix of names of TTL indexes, other indexes with it are dropped
+const retentionPrefix = "retention_"
+
+const (
+	IndexCreated = "created"
+	IndexExists  = "exists"
+	IndexUpdated = "updated"
+	IndexDropped = "dropped"
+)
+
+// RetentionPolicy removes entries with given name or level TTL after they are created.
+// Policy without name and level is default and applies to all entries
+type RetentionPolicy struct {
+	Name  string        `json:"name,omitempty"`
+	Level string        `json:"level,omitempty"`
+	TTL   time.Duration `json:"ttl"`
+}
+
+// IndexStatus reports what EnsureIndexes did with index
+type IndexStatus struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	TTL    string `json:"ttl,omitempty"`
+}
+
+// ParseRetention parses policies written as "default=90d,name:authentication=30d,level:debug=24h".
+// Mongo removes entry by the first expired TTL index, so the shortest matching policy wins and
+// policies can`t be longer than default one
+func ParseRetention(spec string) ([]RetentionPolicy, error) {
+	var policies []RetentionPolicy
+	var defaultTTL time.Duration
+	seen := make(map[string]bool)
+
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		target, value, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid retention policy %q, must be target=duration", item)
+		}
+
+		ttl, err := parseTTL(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid retention of %q: %v", target, err)
+		}
+
+		policy := RetentionPolicy{TTL: ttl}
+
+		kind, name, _ := strings.Cut(strings.TrimSpace(target), ":")
+		switch kind {
+		case "default":
+			defaultTTL = ttl
+		case "name":
+			if name == "" {
+				return nil, errors.New("retention policy name must not be empty")
+			}
+			policy.Name = name
+		case "level":
+			policy.Level, err = NormalizeLevel(name)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unknown retention target %q, must be default, name:<name> or level:<level>", target)
+		}
+
+		if seen[policy.target()] {
+			return nil, fmt.Errorf("duplicate retention policy %q", target)
+		}
+		seen[policy.target()] = true
+
+		policies = append(policies, policy)
+	}
+
+	if defaultTTL > 0 {
+		for _, p := range policies {
+			if p.TTL > defaultTTL {
+				return nil, fmt.Errorf("retention of %s is longer than default retention", p.target())
+			}
+		}
+	}
+
+	return policies, nil
+}
+
+// parseTTL parses duration of time package, and also whole days like 30d
+func parseTTL(value string) (time.Duration, error) {
+	var ttl time.Duration
+
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		ttl = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		ttl, err = time.ParseDuration(value)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if ttl < time.Second {
+		return 0, errors.New("retention must be at least one second")
+	}
+
+	return ttl, nil
+}
+
+// target returns policy as it is written in LOG_RETENTION
+func (p RetentionPolicy) target() string {
+	switch {
+	case p.Name != "":
+		return "name:" + p.Name
+	case p.Level != "":
+		return "level:" + p.Level
+	default:
+		return "default"
+	}
+}
+
+func (p RetentionPolicy) indexName() string {
+	return retentionPrefix + strings.Replace(p.target(), ":", "_", 1)
+}
+
+func (p RetentionPolicy) index() mongo.IndexModel {
+	opts := options.Index().
+		SetName(p.indexName()).
+		SetExpireAfterSeconds(int32(p.TTL / time.Second))
+
+	switch {
+	case p.Name != "":
+		opts.SetPartialFilterExpression(bson.M{"name": p.Name})
+	case p.Level != "":
+		opts.SetPartialFilterExpression(bson.M{"level": p.Level})
+	}
+
+	return mongo.IndexModel{Keys: bson.D{{"created_at", 1}}, Options: opts}
+}
+
+// EnsureIndexes creates indexes of queries and TTL indexes of retention policies. TTL of existing
+// retention index is changed in place and retention indexes without policy are dropped
+func (l *LogEntry) EnsureIndexes(policies []RetentionPolicy) ([]IndexStatus, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), indexTimeout)
+	defer cancel()
+
+	db := client.Database("logs")
+	collection := db.Collection("logs")
+
+	existing, err := listIndexes(ctx, collection)
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []IndexStatus
+
+	for _, index := range []mongo.IndexModel{
+		{Keys: bson.D{{"created_at", -1}}, Options: options.Index().SetName("created_at_-1")},
+		{Keys: bson.D{{"name", 1}, {"created_at", -1}}, Options: options.Index().SetName("name_1_created_at_-1")},
+		{Keys: bson.D{{"level", 1}, {"created_at", -1}}, Options: options.Index().SetName("level_1_created_at_-1")},
+		{Keys: bson.D{{"service", 1}, {"created_at", -1}}, Options: options.Index().SetName("service_1_created_at_-1")},
+		{Keys: bson.D{{"trace_id", 1}}, Options: options.Index().SetName("trace_id_1").SetSparse(true)},
+		{Keys: bson.D{{"user_id", 1}}, Options: options.Index().SetName("user_id_1").SetSparse(true)},
+		{Keys: bson.D{{"email_hashes", 1}}, Options: options.Index().SetName("email_hashes_1").SetSparse(true)},
+		{Keys: bson.D{{"timestamp", -1}}, Options: options.Index().SetName("timestamp_-1")},
+		{Keys: bson.D{{"data", "text"}}, Options: options.Index().SetName("data_text")},
+	} {
+		name := *index.Options.Name
+
+		if _, ok := existing[name]; ok {
+			statuses = append(statuses, IndexStatus{Name: name, Status: IndexExists})
+			continue
+		}
+
+		_, err = collection.Indexes().CreateOne(ctx, index)
+		if err != nil {
+			return statuses, fmt.Errorf("creating index %s: %w", name, err)
+		}
+		statuses = append(statuses, IndexStatus{Name: name, Status: IndexCreated})
+	}
+
+	wanted := make(map[string]bool, len(policies))
+
+	for _, p := range policies {
+		name := p.indexName()
+		wanted[name] = true
+		status := IndexStatus{Name: name, TTL: p.TTL.String()}
+		seconds := int64(p.TTL / time.Second)
+
+		current, ok := existing[name]
+		switch {
+		case ok && current == seconds:
+			status.Status = IndexExists
+		case ok:
+			// collMod changes TTL without rebuilding index
+			err = db.RunCommand(ctx, bson.D{
+				{"collMod", "logs"},
+				{"index", bson.D{{"name", name}, {"expireAfterSeconds", seconds}}},
+			}).Err()
+			status.Status = IndexUpdated
+		default:
+			_, err = collection.Indexes().CreateOne(ctx, p.index())
+			status.Status = IndexCreated
+		}
+		if err != nil {
+			return statuses, fmt.Errorf("applying retention index %s: %w", name, err)
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	var stale []string
+	for name := range existing {
+		if strings.HasPrefix(name, retentionPrefix) && !wanted[name] {
+			stale = append(stale, name)
+		}
+	}
+	sort.Strings(stale)
+
+	for _, name := range stale {
+		_, err = collection.Indexes().DropOne(ctx, name)
+		if err != nil {
+			return statuses, fmt.Errorf("dropping retention index %s: %w", name, err)
+		}
+		statuses = append(statuses, IndexStatus{Name: name, Status: IndexDropped})
+	}
+
+	return statuses, nil
+}
+
+// listIndexes returns names of indexes of collection and their TTL in seconds, which is -1 if
+// index isn`t TTL index. Collection which doesn`t exist yet has no indexes
+func listIndexes(ctx context.Context, collection *mongo.Collection) (map[string]int64, error) {
+	cur, err := collection.Indexes().List(ctx)
+	if err != nil {
+		var cmdErr mongo.CommandError
+		if errors.As(err, &cmdErr) && cmdErr.Name == "NamespaceNotFound" {
+			return map[string]int64{}, nil
+		}
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	indexes := make(map[string]int64)
+
+	for cur.Next(ctx) {
+		var index struct {
+			Name               string `bson:"name"`
+			ExpireAfterSeconds *int64 `bson:"expireAfterSeconds"`
+		}
+
+		err = cur.Decode(&index)
+		if err != nil {
+			return nil, err
+		}
+
+		indexes[index.Name] = -1
+		if index.ExpireAfterSeconds != nil {
+			indexes[index.Name] = *index.ExpireAfterSeconds
+		}
+	}
+
+	return indexes, cur.Err()
+}
@@ -2,11 +2,14 @@ package data
 
 import (
 	"context"
+	"fmt"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"log"
+	"regexp"
+	"strings"
 	"time"
 )
 
@@ -16,31 +19,69 @@ func New(mongo *mongo.Client) Models {
 	client = mongo
 
 	return Models{
-		LogEntry: LogEntry{},
+		LogEntry:    LogEntry{},
+		AuditRecord: AuditRecord{},
 	}
 }
 
 type Models struct {
-	LogEntry LogEntry
+	LogEntry    LogEntry
+	AuditRecord AuditRecord
 }
 
+const (
+	LevelDebug = "debug"
+	LevelInfo  = "info"
+	LevelWarn  = "warn"
+	LevelError = "error"
+	LevelFatal = "fatal"
+)
+
+var levels = []string{LevelDebug, LevelInfo, LevelWarn, LevelError, LevelFatal}
+
 type LogEntry struct {
-	ID        string    `bson:"_id,omitempty" json:"id,omitempty"`
-	Name      string    `bson:"name" json:"name"`
-	Data      string    `bson:"data" json:"data"`
-	CreatedAt time.Time `bson:"created_at" json:"created_at"`
-	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+	ID          string         `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID      string         `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	Name        string         `bson:"name" json:"name"`
+	Data        string         `bson:"data" json:"data"`
+	Level       string         `bson:"level" json:"level"`
+	Service     string         `bson:"service,omitempty" json:"service,omitempty"`
+	TraceID     string         `bson:"trace_id,omitempty" json:"trace_id,omitempty"`
+	Fields      map[string]any `bson:"fields,omitempty" json:"fields,omitempty"`
+	EmailHashes []string       `bson:"email_hashes,omitempty" json:"-"`
+	Timestamp   time.Time      `bson:"timestamp" json:"timestamp"`
+	CreatedAt   time.Time      `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time      `bson:"updated_at" json:"updated_at"`
+}
+
+// NormalizeLevel returns lower case level, empty level is info
+func NormalizeLevel(level string) (string, error) {
+	level = strings.ToLower(strings.TrimSpace(level))
+	if level == "" {
+		return LevelInfo, nil
+	}
+	if level == "warning" {
+		level = LevelWarn
+	}
+
+	for _, l := range levels {
+		if l == level {
+			return level, nil
+		}
+	}
+
+	return "", fmt.Errorf("invalid level %q, must be one of %s", level, strings.Join(levels, ", "))
 }
 
 func (l *LogEntry) Insert(entry LogEntry) error {
 	collection := client.Database("logs").Collection("logs")
 
-	_, err := collection.InsertOne(context.TODO(), LogEntry{
-		Name:      entry.Name,
-		Data:      entry.Data,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
-	})
+	entry, err := Prepare(entry)
+	if err != nil {
+		return err
+	}
+
+	_, err = collection.InsertOne(context.TODO(), entry)
 	if err != nil {
 		log.Println("Error inserting into logs:", err)
 		return err
@@ -49,6 +90,65 @@ func (l *LogEntry) Insert(entry LogEntry) error {
 	return nil
 }
 
+// InsertMany inserts prepared entries in one request and sets their ids. Insert is unordered,
+// so one bad entry doesn`t stop the rest of batch
+func (l *LogEntry) InsertMany(entries []LogEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	collection := client.Database("logs").Collection("logs")
+
+	docs := make([]any, len(entries))
+	for i := range entries {
+		docs[i] = entries[i]
+	}
+
+	result, err := collection.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	if err != nil {
+		return err
+	}
+
+	// ids are set, so published entries can be found by id
+	for i, id := range result.InsertedIDs {
+		if oid, ok := id.(primitive.ObjectID); ok && i < len(entries) {
+			entries[i].ID = oid.Hex()
+		}
+	}
+
+	return nil
+}
+
+// Prepare validates entry, removes sensitive values and sets its level and times as they are stored
+func Prepare(entry LogEntry) (LogEntry, error) {
+	level, err := NormalizeLevel(entry.Level)
+	if err != nil {
+		return entry, err
+	}
+
+	entry = redactor.Redact(entry)
+
+	now := time.Now()
+
+	// timestamp is time of event in service, it is time of insert if service didn`t send it
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = now
+	}
+
+	return LogEntry{
+		UserID:      entry.UserID,
+		Name:        entry.Name,
+		Data:        entry.Data,
+		Level:       level,
+		Service:     entry.Service,
+		TraceID:     entry.TraceID,
+		Fields:      entry.Fields,
+		EmailHashes: entry.EmailHashes,
+		Timestamp:   entry.Timestamp,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}, nil
+}
+
 func (l *LogEntry) All() ([]*LogEntry, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
@@ -143,3 +243,55 @@ func (l *LogEntry) Update() (*mongo.UpdateResult, error) {
 
 	return result, nil
 }
+
+func (l *LogEntry) DeleteByUser(userID, email string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	collection := client.Database("logs").Collection("logs")
+
+	// email is written into free text data of log entries, masked email is found by its hash
+	filter := bson.M{"$or": bson.A{
+		bson.M{"user_id": userID},
+		bson.M{"data": matchEmail(email)},
+		bson.M{"email_hashes": redactor.HashEmail(email)},
+	}}
+
+	result, err := collection.DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.DeletedCount, nil
+}
+
+// matchEmail returns regex matching email as whole address, so bob@x.com doesn`t match
+// jimbob@x.com or bob@x.com.au. Dot after address is the end of sentence
+func matchEmail(email string) primitive.Regex {
+	return primitive.Regex{
+		Pattern: `(^|[^A-Za-z0-9._%+-])` + regexp.QuoteMeta(email) + `\.?($|[^A-Za-z0-9.-])`,
+		Options: "i",
+	}
+}
+
+func (l *LogEntry) Backfill(userID, email string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	collection := client.Database("logs").Collection("logs")
+
+	filter := bson.M{
+		"user_id": bson.M{"$exists": false},
+		"$or": bson.A{
+			bson.M{"data": matchEmail(email)},
+			bson.M{"email_hashes": redactor.HashEmail(email)},
+		},
+	}
+
+	result, err := collection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"user_id": userID}})
+	if err != nil {
+		return 0, err
+	}
+
+	return result.ModifiedCount, nil
+}
@@ -0,0 +1,229 @@
+package data
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"strings"
+	"time"
+)
+
+const (
+	defaultLimit = 50
+	maxLimit     = 500
+)
+
+// sortFields are fields which logs can be sorted by, cursor stores value of this field
+var sortFields = map[string]bool{
+	"created_at": true,
+	"timestamp":  true,
+}
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// LogFilter stores filters, sort order and cursor of logs list
+type LogFilter struct {
+	Name    string     `json:"name,omitempty"`
+	Level   string     `json:"level,omitempty"`
+	Service string     `json:"service,omitempty"`
+	UserID  string     `json:"user_id,omitempty"`
+	TraceID string     `json:"trace_id,omitempty"`
+	From    *time.Time `json:"from,omitempty"`
+	To      *time.Time `json:"to,omitempty"`
+	Search  string     `json:"search,omitempty"`
+	Sort    string     `json:"sort,omitempty"`
+	Limit   int        `json:"limit,omitempty"`
+	Cursor  string     `json:"cursor,omitempty"`
+}
+
+// cursor stores sort value and id of the last entry of page
+type cursor struct {
+	Value time.Time `json:"v"`
+	ID    string    `json:"id"`
+}
+
+// Validate sets default values of filter and checks it
+func (f *LogFilter) Validate() error {
+	if f.Limit == 0 {
+		f.Limit = defaultLimit
+	}
+	if f.Sort == "" {
+		f.Sort = "-created_at"
+	}
+
+	if f.Limit < 0 || f.Limit > maxLimit {
+		return fmt.Errorf("limit must be between 1 and %d", maxLimit)
+	}
+	if !sortFields[strings.TrimPrefix(f.Sort, "-")] {
+		return fmt.Errorf("invalid sort value %q", f.Sort)
+	}
+	if f.Level != "" {
+		level, err := NormalizeLevel(f.Level)
+		if err != nil {
+			return err
+		}
+		f.Level = level
+	}
+	if f.From != nil && f.To != nil && f.From.After(*f.To) {
+		return errors.New("from must be before to")
+	}
+
+	return nil
+}
+
+// query returns mongo filter of all fields of filter except cursor
+func (f *LogFilter) query() bson.M {
+	query := bson.M{}
+
+	if f.Name != "" {
+		query["name"] = f.Name
+	}
+	if f.Level != "" {
+		query["level"] = f.Level
+	}
+	if f.Service != "" {
+		query["service"] = f.Service
+	}
+	if f.UserID != "" {
+		query["user_id"] = f.UserID
+	}
+	if f.TraceID != "" {
+		query["trace_id"] = f.TraceID
+	}
+
+	createdAt := bson.M{}
+	if f.From != nil {
+		createdAt["$gte"] = *f.From
+	}
+	if f.To != nil {
+		createdAt["$lte"] = *f.To
+	}
+	if len(createdAt) > 0 {
+		query["created_at"] = createdAt
+	}
+
+	if f.Search != "" {
+		query["$text"] = bson.M{"$search": f.Search}
+	}
+
+	return query
+}
+
+func (f *LogFilter) sortField() string {
+	return strings.TrimPrefix(f.Sort, "-")
+}
+
+func (f *LogFilter) descending() bool {
+	return strings.HasPrefix(f.Sort, "-")
+}
+
+// Find returns page of logs matching filter and cursor of the next page, which is empty on the last page
+func (l *LogEntry) Find(filter LogFilter) ([]*LogEntry, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	collection := client.Database("logs").Collection("logs")
+
+	query := filter.query()
+
+	field := filter.sortField()
+	direction, compare := 1, "$gt"
+	if filter.descending() {
+		direction, compare = -1, "$lt"
+	}
+
+	if filter.Cursor != "" {
+		c, err := decodeCursor(filter.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+
+		docID, err := primitive.ObjectIDFromHex(c.ID)
+		if err != nil {
+			return nil, "", ErrInvalidCursor
+		}
+
+		// entries after the last one of previous page, id breaks ties of equal times
+		query = bson.M{"$and": bson.A{query, bson.M{"$or": bson.A{
+			bson.M{field: bson.M{compare: c.Value}},
+			bson.M{field: c.Value, "_id": bson.M{compare: docID}},
+		}}}}
+	}
+
+	opts := options.Find()
+	opts.SetSort(bson.D{{field, direction}, {"_id", direction}})
+	// one more entry shows if there is the next page
+	opts.SetLimit(int64(filter.Limit + 1))
+
+	cur, err := collection.Find(ctx, query, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	defer cur.Close(ctx)
+
+	logs := []*LogEntry{}
+
+	for cur.Next(ctx) {
+		var item LogEntry
+
+		err = cur.Decode(&item)
+		if err != nil {
+			return nil, "", err
+		}
+
+		logs = append(logs, &item)
+	}
+
+	if err = cur.Err(); err != nil {
+		return nil, "", err
+	}
+
+	if len(logs) <= filter.Limit {
+		return logs, "", nil
+	}
+
+	logs = logs[:filter.Limit]
+	last := logs[len(logs)-1]
+
+	value := last.CreatedAt
+	if field == "timestamp" {
+		value = last.Timestamp
+	}
+
+	next, err := encodeCursor(cursor{Value: value, ID: last.ID})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return logs, next, nil
+}
+
+func encodeCursor(c cursor) (string, error) {
+	out, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(out), nil
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	out, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var c cursor
+
+	err = json.Unmarshal(out, &c)
+	if err != nil || c.ID == "" {
+		return nil, ErrInvalidCursor
+	}
+
+	return &c, nil
+}
@@ -0,0 +1,238 @@
+package data
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+// emailPattern matches emails in free text, local part is masked and domain is kept
+var emailPattern = regexp.MustCompile(`([A-Za-z0-9._%+-])[A-Za-z0-9._%+-]*@([A-Za-z0-9.-]+\.[A-Za-z]{2,})`)
+
+// RedactionRules configures what is removed from entries before they are stored. Values of Fields
+// are removed from fields of entry and from key=value or "key": "value" pairs in data, Patterns
+// are removed from data and string fields. Masked emails are stored as HMAC with EmailHashKey,
+// so entries of user can be erased without storing emails
+type RedactionRules struct {
+	Fields       []string
+	Patterns     []string
+	MaskEmails   bool
+	EmailHashKey []byte
+}
+
+// Redactor removes sensitive values from entries
+type Redactor struct {
+	fields       map[string]bool
+	pairs        *regexp.Regexp
+	patterns     []*regexp.Regexp
+	maskEmails   bool
+	emailHashKey []byte
+}
+
+// redactor is used by Prepare, it is changed by SetRedactor on start
+var redactor = defaultRedactor()
+
+// DefaultRedactionRules returns rules removing passwords, tokens and keys, bearer tokens and
+// API keys of authentication service, and masking emails
+func DefaultRedactionRules() RedactionRules {
+	return RedactionRules{
+		Fields: []string{
+			"password",
+			"password_hash",
+			"new_password",
+			"old_password",
+			"secret",
+			"token",
+			"session_token",
+			"access_token",
+			"refresh_token",
+			"api_key",
+			"authorization",
+		},
+		Patterns: []string{
+			`(?i)bearer\s+[A-Za-z0-9._~+/-]+=*`,
+			`\bgm_[a-z2-7]{16,}\b`,
+		},
+		MaskEmails: true,
+	}
+}
+
+func defaultRedactor() *Redactor {
+	// emails are masked only with hash key, which is set by SetRedactor on start
+	rules := DefaultRedactionRules()
+	rules.MaskEmails = false
+
+	r, err := NewRedactor(rules)
+	if err != nil {
+		panic(err)
+	}
+
+	return r
+}
+
+// SetRedactor makes Prepare use redactor
+func SetRedactor(r *Redactor) {
+	redactor = r
+}
+
+// NewRedactor compiles rules. Emails can be masked only with hash key, otherwise entries with
+// masked emails of users without user_id couldn`t be erased
+func NewRedactor(rules RedactionRules) (*Redactor, error) {
+	if rules.MaskEmails && len(rules.EmailHashKey) == 0 {
+		return nil, errors.New("email hash key is required to mask emails")
+	}
+
+	r := &Redactor{
+		fields:       make(map[string]bool, len(rules.Fields)),
+		maskEmails:   rules.MaskEmails,
+		emailHashKey: rules.EmailHashKey,
+	}
+
+	var names []string
+	for _, field := range rules.Fields {
+		field = strings.ToLower(strings.TrimSpace(field))
+		if field == "" || r.fields[field] {
+			continue
+		}
+		r.fields[field] = true
+		names = append(names, regexp.QuoteMeta(field))
+	}
+
+	if len(names) > 0 {
+		// key, separator with optional quotes, value up to quote, space or delimiter
+		r.pairs = regexp.MustCompile(`(?i)(\b(?:` + strings.Join(names, "|") + `)["']?\s*[:=]\s*["']?)([^"'\s,;&}]+)`)
+	}
+
+	for _, pattern := range rules.Patterns {
+		if strings.TrimSpace(pattern) == "" {
+			continue
+		}
+
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %q: %v", pattern, err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+
+	return r, nil
+}
+
+// Redact returns entry without sensitive values. Fields are copied, so map of caller isn`t changed.
+// Hashes of masked emails are added to entry, so DeleteByUser and Backfill find it by email
+func (r *Redactor) Redact(entry LogEntry) LogEntry {
+	if r.maskEmails {
+		entry.EmailHashes = r.emailHashes(entry)
+	}
+
+	entry.Data = r.redactString(entry.Data)
+
+	if entry.Fields != nil {
+		entry.Fields = r.redactMap(entry.Fields)
+	}
+
+	return entry
+}
+
+func (r *Redactor) redactString(s string) string {
+	if s == "" {
+		return s
+	}
+
+	// patterns go first, so "Authorization: Bearer token" isn`t cut after "Bearer" by pairs
+	for _, re := range r.patterns {
+		s = re.ReplaceAllString(s, redacted)
+	}
+
+	if r.pairs != nil {
+		s = r.pairs.ReplaceAllString(s, "${1}"+redacted)
+	}
+
+	if r.maskEmails {
+		s = emailPattern.ReplaceAllString(s, "${1}***@${2}")
+	}
+
+	return s
+}
+
+func (r *Redactor) redactMap(m map[string]any) map[string]any {
+	out := make(map[string]any, len(m))
+
+	for key, value := range m {
+		if r.fields[strings.ToLower(key)] {
+			out[key] = redacted
+			continue
+		}
+		out[key] = r.redactValue(value)
+	}
+
+	return out
+}
+
+func (r *Redactor) redactValue(value any) any {
+	switch v := value.(type) {
+	case string:
+		return r.redactString(v)
+	case map[string]any:
+		return r.redactMap(v)
+	case []any:
+		out := make([]any, len(v))
+		for i := range v {
+			out[i] = r.redactValue(v[i])
+		}
+		return out
+	default:
+		return value
+	}
+}
+
+// HashEmail returns HMAC of lower case email, it is empty if emails aren`t masked
+func (r *Redactor) HashEmail(email string) string {
+	if len(r.emailHashKey) == 0 {
+		return ""
+	}
+
+	mac := hmac.New(sha256.New, r.emailHashKey)
+	mac.Write([]byte(strings.ToLower(email)))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// emailHashes returns hashes of all emails in data and string fields of entry
+func (r *Redactor) emailHashes(entry LogEntry) []string {
+	var hashes []string
+	seen := make(map[string]bool)
+
+	var walk func(value any)
+	walk = func(value any) {
+		switch v := value.(type) {
+		case string:
+			for _, email := range emailPattern.FindAllString(v, -1) {
+				hash := r.HashEmail(email)
+				if !seen[hash] {
+					seen[hash] = true
+					hashes = append(hashes, hash)
+				}
+			}
+		case map[string]any:
+			for _, item := range v {
+				walk(item)
+			}
+		case []any:
+			for _, item := range v {
+				walk(item)
+			}
+		}
+	}
+
+	walk(entry.Data)
+	walk(entry.Fields)
+
+	return hashes
+}
@@ -0,0 +1,230 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ErrPartialWrite is returned by sink which wrote some entries of batch before error, so batch
+// isn`t retried and written entries aren`t duplicated
+var ErrPartialWrite = errors.New("batch is written partly")
+
+// Sink stores flushed batches of entries. Buffer writes every batch to all its sinks
+type Sink interface {
+	Name() string
+	Write(entries []LogEntry) error
+	Close() error
+}
+
+// MongoSink inserts entries into logs collection
+type MongoSink struct{}
+
+func (s *MongoSink) Name() string {
+	return "mongo"
+}
+
+func (s *MongoSink) Write(entries []LogEntry) error {
+	l := LogEntry{}
+	return l.InsertMany(entries)
+}
+
+// Close does nothing, client is disconnected by main
+func (s *MongoSink) Close() error {
+	return nil
+}
+
+// StdoutSink writes entries as JSON lines to standard output
+type StdoutSink struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func NewStdoutSink() *StdoutSink {
+	return &StdoutSink{out: os.Stdout}
+}
+
+func (s *StdoutSink) Name() string {
+	return "stdout"
+}
+
+func (s *StdoutSink) Write(entries []LogEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := writeJSONLines(s.out, entries)
+
+	return err
+}
+
+func (s *StdoutSink) Close() error {
+	return nil
+}
+
+// FileSink writes entries as JSON lines to file. File is renamed to path.1 when it reaches
+// maxSize bytes, older files are shifted and the oldest of maxBackups is removed.
+// File which failed to rotate or write is reopened on the next write
+type FileSink struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+	closed     bool
+}
+
+// NewFileSink opens file for appending, directory of file is created if it doesn`t exist
+func NewFileSink(path string, maxSize int64, maxBackups int) (*FileSink, error) {
+	s := &FileSink{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.open()
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *FileSink) Name() string {
+	return "file"
+}
+
+func (s *FileSink) Write(entries []LogEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return os.ErrClosed
+	}
+
+	if s.file != nil && s.size >= s.maxSize {
+		err := s.rotate()
+		if err != nil {
+			// entries are appended to not rotated file until rotation works
+			log.Printf("Error rotating log file %s: %v", s.path, err)
+		}
+	}
+
+	if s.file == nil {
+		err := s.open()
+		if err != nil {
+			return err
+		}
+	}
+
+	n, err := writeJSONLines(s.file, entries)
+	s.size += n
+
+	if err != nil {
+		s.file.Close()
+		s.file = nil
+	}
+
+	return err
+}
+
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+
+	if s.file == nil {
+		return nil
+	}
+
+	err := s.file.Close()
+	s.file = nil
+
+	return err
+}
+
+func (s *FileSink) open() error {
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	s.file = file
+	s.size = info.Size()
+
+	return nil
+}
+
+func (s *FileSink) rotate() error {
+	err := s.file.Close()
+	s.file = nil
+	if err != nil {
+		return err
+	}
+
+	if s.maxBackups > 0 {
+		for i := s.maxBackups - 1; i >= 1; i-- {
+			err = os.Rename(fmt.Sprintf("%s.%d", s.path, i), fmt.Sprintf("%s.%d", s.path, i+1))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+
+		err = os.Rename(s.path, s.path+".1")
+	} else {
+		err = os.Remove(s.path)
+	}
+	if err != nil {
+		return err
+	}
+
+	return s.open()
+}
+
+// countingWriter counts written bytes, so size of file isn`t read from disk after every batch
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// writeJSONLines writes entries and returns number of written bytes. If some entries were written
+// before error, error is ErrPartialWrite
+func writeJSONLines(w io.Writer, entries []LogEntry) (int64, error) {
+	c := &countingWriter{w: w}
+	enc := json.NewEncoder(c)
+
+	for i := range entries {
+		err := enc.Encode(entries[i])
+		if err != nil {
+			if c.n > 0 {
+				return c.n, fmt.Errorf("%w: %v", ErrPartialWrite, err)
+			}
+			return c.n, err
+		}
+	}
+
+	return c.n, nil
+}
@@ -0,0 +1,206 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"time"
+)
+
+const (
+	maxTop     = 100
+	maxBuckets = 10000
+)
+
+// groupFields are fields which entries can be grouped by
+var groupFields = map[string]bool{
+	"name":    true,
+	"level":   true,
+	"service": true,
+}
+
+// intervals are units of $dateTrunc which entries can be bucketed by
+var intervals = map[string]bool{
+	"minute": true,
+	"hour":   true,
+	"day":    true,
+	"week":   true,
+	"month":  true,
+}
+
+// StatsQuery counts entries matching filter grouped by fields and by time buckets of interval.
+// Top keeps only groups with the most entries. Sort, limit and cursor of filter are ignored
+type StatsQuery struct {
+	LogFilter
+	GroupBy  []string `json:"group_by,omitempty"`
+	Interval string   `json:"interval,omitempty"`
+	Timezone string   `json:"timezone,omitempty"`
+	Top      int      `json:"top,omitempty"`
+}
+
+// StatsBucket stores number of entries of group in time bucket
+type StatsBucket struct {
+	Group map[string]string `json:"group,omitempty"`
+	Time  *time.Time        `json:"time,omitempty"`
+	Count int64             `json:"count"`
+}
+
+// Validate checks query and its filter
+func (q *StatsQuery) Validate() error {
+	err := q.LogFilter.Validate()
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool, len(q.GroupBy))
+	for _, field := range q.GroupBy {
+		if !groupFields[field] {
+			return fmt.Errorf("invalid group_by field %q, must be name, level or service", field)
+		}
+		if seen[field] {
+			return fmt.Errorf("duplicate group_by field %q", field)
+		}
+		seen[field] = true
+	}
+
+	if q.Interval != "" && !intervals[q.Interval] {
+		return fmt.Errorf("invalid interval %q, must be minute, hour, day, week or month", q.Interval)
+	}
+	if q.Timezone != "" && q.Interval == "" {
+		return errors.New("timezone needs interval")
+	}
+
+	if q.Top < 0 || q.Top > maxTop {
+		return fmt.Errorf("top must be between 0 and %d", maxTop)
+	}
+	if q.Top > 0 && len(q.GroupBy) == 0 {
+		return errors.New("top needs group_by")
+	}
+
+	return nil
+}
+
+// Stats counts entries with aggregation pipeline. If Top is set, groups with the most entries
+// in whole range are found first and only their buckets are counted
+func (l *LogEntry) Stats(q StatsQuery) ([]StatsBucket, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	collection := client.Database("logs").Collection("logs")
+
+	match := q.query()
+
+	if q.Top > 0 {
+		top, err := aggregateStats(ctx, collection, bson.A{
+			bson.M{"$match": match},
+			bson.M{"$group": bson.M{"_id": q.groupID(false), "count": bson.M{"$sum": 1}}},
+			bson.M{"$sort": bson.D{{"count", -1}, {"_id", 1}}},
+			bson.M{"$limit": q.Top},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if q.Interval == "" || len(top) == 0 {
+			return top, nil
+		}
+
+		groups := bson.A{}
+		for _, bucket := range top {
+			group := bson.M{}
+			for key, value := range bucket.Group {
+				// empty group has entries without field
+				if value == "" {
+					group[key] = bson.M{"$in": bson.A{nil, ""}}
+					continue
+				}
+				group[key] = value
+			}
+			groups = append(groups, group)
+		}
+
+		match = bson.M{"$and": bson.A{match, bson.M{"$or": groups}}}
+	}
+
+	var sort bson.D
+	if q.Interval != "" {
+		sort = bson.D{{"_id.time", 1}, {"count", -1}}
+	} else {
+		sort = bson.D{{"count", -1}}
+	}
+	for _, field := range q.GroupBy {
+		sort = append(sort, bson.E{Key: "_id." + field, Value: 1})
+	}
+
+	return aggregateStats(ctx, collection, bson.A{
+		bson.M{"$match": match},
+		bson.M{"$group": bson.M{"_id": q.groupID(true), "count": bson.M{"$sum": 1}}},
+		bson.M{"$sort": sort},
+		bson.M{"$limit": maxBuckets},
+	})
+}
+
+// groupID returns _id of $group stage, missing fields are grouped as empty string
+func (q *StatsQuery) groupID(withTime bool) bson.M {
+	id := bson.M{}
+
+	for _, field := range q.GroupBy {
+		id[field] = bson.M{"$ifNull": bson.A{"$" + field, ""}}
+	}
+
+	if withTime && q.Interval != "" {
+		trunc := bson.M{"date": "$created_at", "unit": q.Interval}
+		if q.Timezone != "" {
+			trunc["timezone"] = q.Timezone
+		}
+		id["time"] = bson.M{"$dateTrunc": trunc}
+	}
+
+	return id
+}
+
+func aggregateStats(ctx context.Context, collection *mongo.Collection, pipeline bson.A) ([]StatsBucket, error) {
+	cur, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	buckets := []StatsBucket{}
+
+	for cur.Next(ctx) {
+		var row struct {
+			ID    bson.M `bson:"_id"`
+			Count int64  `bson:"count"`
+		}
+
+		err = cur.Decode(&row)
+		if err != nil {
+			return nil, err
+		}
+
+		bucket := StatsBucket{Count: row.Count}
+
+		for key, value := range row.ID {
+			if key == "time" {
+				if t, ok := value.(primitive.DateTime); ok {
+					bucketTime := t.Time().UTC()
+					bucket.Time = &bucketTime
+				}
+				continue
+			}
+
+			if bucket.Group == nil {
+				bucket.Group = make(map[string]string)
+			}
+			bucket.Group[key] = fmt.Sprint(value)
+		}
+
+		buckets = append(buckets, bucket)
+	}
+
+	return buckets, cur.Err()
+}
@@ -0,0 +1,144 @@
+package data
+
+import (
+	"strings"
+	"sync"
+)
+
+// subscriberBuffer is how many entries subscriber may fall behind before its entries are dropped
+const subscriberBuffer = 256
+
+// Hub fans out stored entries to subscribers of live tail. It sees only entries written by this
+// instance of service, change streams aren`t used because mongo runs without replica set
+type Hub struct {
+	mu          sync.Mutex
+	subscribers map[*Subscription]struct{}
+	closed      bool
+}
+
+// Subscription receives entries matching its filter. Entries channel is closed when hub is closed
+type Subscription struct {
+	Entries chan LogEntry
+	filter  LogFilter
+
+	mu      sync.Mutex
+	dropped int
+}
+
+func NewHub() *Hub {
+	return &Hub{subscribers: make(map[*Subscription]struct{})}
+}
+
+// Subscribe returns subscription to entries matching filter
+func (h *Hub) Subscribe(filter LogFilter) *Subscription {
+	s := &Subscription{
+		Entries: make(chan LogEntry, subscriberBuffer),
+		filter:  filter,
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		close(s.Entries)
+		return s
+	}
+
+	h.subscribers[s] = struct{}{}
+
+	return s
+}
+
+// Unsubscribe stops sending entries to subscription
+func (h *Hub) Unsubscribe(s *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.subscribers[s]; ok {
+		delete(h.subscribers, s)
+		close(s.Entries)
+	}
+}
+
+// Publish sends entries to matching subscribers. It never blocks, entries of slow subscriber are dropped
+func (h *Hub) Publish(entries []LogEntry) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for s := range h.subscribers {
+		for _, entry := range entries {
+			if !s.filter.Matches(entry) {
+				continue
+			}
+
+			select {
+			case s.Entries <- entry:
+			default:
+				s.mu.Lock()
+				s.dropped++
+				s.mu.Unlock()
+			}
+		}
+	}
+}
+
+// Close closes all subscriptions, so streams end on shutdown
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for s := range h.subscribers {
+		delete(h.subscribers, s)
+		close(s.Entries)
+	}
+}
+
+// Dropped returns and resets number of entries dropped since last call
+func (s *Subscription) Dropped() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dropped := s.dropped
+	s.dropped = 0
+
+	return dropped
+}
+
+// Matches reports whether entry matches filter like query of Find does. Search matches
+// words of data case insensitively instead of text index
+func (f *LogFilter) Matches(entry LogEntry) bool {
+	if f.Name != "" && entry.Name != f.Name {
+		return false
+	}
+	if f.Level != "" && entry.Level != f.Level {
+		return false
+	}
+	if f.Service != "" && entry.Service != f.Service {
+		return false
+	}
+	if f.UserID != "" && entry.UserID != f.UserID {
+		return false
+	}
+	if f.TraceID != "" && entry.TraceID != f.TraceID {
+		return false
+	}
+	if f.From != nil && entry.CreatedAt.Before(*f.From) {
+		return false
+	}
+	if f.To != nil && entry.CreatedAt.After(*f.To) {
+		return false
+	}
+
+	if f.Search != "" {
+		data := strings.ToLower(entry.Data)
+		for _, word := range strings.Fields(strings.ToLower(f.Search)) {
+			if strings.Contains(data, word) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return true
+}
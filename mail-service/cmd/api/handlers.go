@@ -3,17 +3,21 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
 )
 
 func (app *Config) SendMail(w http.ResponseWriter, r *http.Request) {
 	type mailMessage struct {
-		From    string `json:"from"`
-		To      string `json:"to"`
-		Subject string `json:"subject"`
-		Message string `json:"message"`
+		From     string         `json:"from"`
+		To       string         `json:"to"`
+		Subject  string         `json:"subject"`
+		Message  string         `json:"message"`
+		Template string         `json:"template,omitempty"`
+		Data     map[string]any `json:"data,omitempty"`
 	}
 
 	var requestPayload mailMessage
@@ -24,11 +28,18 @@ func (app *Config) SendMail(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if strings.ContainsAny(requestPayload.Template, "./\\") {
+		app.errorJSON(w, errors.New("invalid name of template"))
+		return
+	}
+
 	msg := Message{
-		From:    requestPayload.From,
-		To:      requestPayload.To,
-		Subject: requestPayload.Subject,
-		Data:    requestPayload.Message,
+		From:     requestPayload.From,
+		To:       requestPayload.To,
+		Subject:  requestPayload.Subject,
+		Template: requestPayload.Template,
+		Data:     requestPayload.Message,
+		DataMap:  requestPayload.Data,
 	}
 
 	err = app.Mailer.SendSMTPMessage(msg)
@@ -50,12 +61,16 @@ func (app *Config) SendMail(w http.ResponseWriter, r *http.Request) {
 
 func (app *Config) logRequest(name, data string) {
 	var entry struct {
-		Name string `json:"name"`
-		Data string `json:"data"`
+		Name    string `json:"name"`
+		Data    string `json:"data"`
+		Level   string `json:"level"`
+		Service string `json:"service"`
 	}
 
 	entry.Name = name
 	entry.Data = data
+	entry.Level = "info"
+	entry.Service = "mail-service"
 
 	jsonData, _ := json.MarshalIndent(entry, "", "\t")
 	logServiceURL := "http://logger-service/log"
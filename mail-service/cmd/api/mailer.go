@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"fmt"
 	"github.com/vanng822/go-premailer/premailer"
 	mail "github.com/xhit/go-simple-mail/v2"
 	"html/template"
@@ -24,6 +25,7 @@ type Message struct {
 	FromName    string
 	To          string
 	Subject     string
+	Template    string
 	Attachments []string
 	Data        any
 	DataMap     map[string]any
@@ -38,10 +40,18 @@ func (m *Mail) SendSMTPMessage(msg Message) error {
 		msg.FromName = m.FromName
 	}
 
+	if msg.Template == "" {
+		msg.Template = "mail"
+	}
+
 	data := map[string]any{
 		"message": msg.Data,
 	}
 
+	for key, value := range msg.DataMap {
+		data[key] = value
+	}
+
 	msg.DataMap = data
 
 	formattedMesage, err := m.buildHTMLMessage(msg)
@@ -92,7 +102,7 @@ func (m *Mail) SendSMTPMessage(msg Message) error {
 }
 
 func (m *Mail) buildHTMLMessage(msg Message) (string, error) {
-	templateToRender := "./templates/mail.html"
+	templateToRender := fmt.Sprintf("./templates/%s.html", msg.Template)
 
 	t, err := template.New("email-html").ParseFiles(templateToRender)
 	if err != nil {
@@ -114,7 +124,7 @@ func (m *Mail) buildHTMLMessage(msg Message) (string, error) {
 }
 
 func (m *Mail) buildPlainTextMessage(msg Message) (string, error) {
-	templateToRender := "./templates/mail.plain.html"
+	templateToRender := fmt.Sprintf("./templates/%s.plain.html", msg.Template)
 
 	t, err := template.New("email-plain").ParseFiles(templateToRender)
 	if err != nil {